@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"godev/client"
+)
+
+// buildCache compiles a Go or C --script once per remote platform per
+// run. Every run builds again rather than trusting a key of its own, which
+// could not know every file a build reads: go build's own cache knows, and
+// makes a rebuild with nothing changed cheap. Binaries are kept under the
+// user's cache directory, and an unchanged one comes out byte for byte the
+// same, so hosts that already have it in their script cache are not sent
+// it again.
+type buildCache struct {
+	source string
+	lang   string
+	cc     string
+	dir    string
+
+	mu     sync.Mutex
+	builds map[client.Platform]*build
+}
+
+type build struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+// buildLang returns the language godev knows how to build path from, or ""
+// when the script is uploaded as it is.
+func buildLang(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return "go"
+	case ".c":
+		return "c"
+	}
+	return ""
+}
+
+// newBuildCache prepares to build source. cc is the --cc template for C
+// sources.
+func newBuildCache(source, cc string) (*buildCache, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	// The key only keeps apart the binaries of different sources and
+	// compilers; it does not decide whether to build.
+	src, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(src + "\x00" + cc))
+	key := hex.EncodeToString(sum[:])[:16]
+
+	return &buildCache{
+		source: source,
+		lang:   buildLang(source),
+		cc:     cc,
+		dir:    filepath.Join(base, "godev", "build", key),
+		builds: map[client.Platform]*build{},
+	}, nil
+}
+
+// forHost probes h's platform and returns the binary built for it.
+func (c *buildCache) forHost(h client.HostInfo, opts client.Options) (string, error) {
+	p, err := client.ProbePlatform(h, opts)
+	if err != nil {
+		return "", err
+	}
+	return c.binary(p)
+}
+
+// binary returns the binary for p, building it the first time any host
+// on p asks for it. Hosts asking while it builds wait for it.
+func (c *buildCache) binary(p client.Platform) (string, error) {
+	c.mu.Lock()
+	b, ok := c.builds[p]
+	if !ok {
+		b = &build{}
+		c.builds[p] = b
+	}
+	c.mu.Unlock()
+
+	b.once.Do(func() {
+		b.path, b.err = c.compile(p)
+		if b.err != nil {
+			b.err = fmt.Errorf("build %s for %s: %w", filepath.Base(c.source), p, b.err)
+		}
+	})
+	return b.path, b.err
+}
+
+func (c *buildCache) compile(p client.Platform) (string, error) {
+	name := strings.TrimSuffix(filepath.Base(c.source), filepath.Ext(c.source))
+	if p.OS == "windows" {
+		name += ".exe"
+	}
+	dir := filepath.Join(c.dir, p.OS+"_"+p.Arch)
+	out := filepath.Join(dir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	// Build next to the final name and rename, so another godev building
+	// the same thing never sees half a binary.
+	tmp, err := os.CreateTemp(dir, ".build-*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	src, err := filepath.Abs(c.source)
+	if err != nil {
+		return "", err
+	}
+	var cmd *exec.Cmd
+	switch c.lang {
+	case "go":
+		cmd = exec.Command("go", "build", "-trimpath", "-o", tmp.Name(), filepath.Base(src))
+		cmd.Dir = filepath.Dir(src)
+		cmd.Env = append(os.Environ(), "GOOS="+p.OS, "GOARCH="+p.Arch, "CGO_ENABLED=0")
+	case "c":
+		cc, err := c.compiler(p)
+		if err != nil {
+			return "", err
+		}
+		cmd = exec.Command(cc[0], append(cc[1:], "-O2", "-o", tmp.Name(), src)...)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return "", err
+	}
+	return out, os.Rename(tmp.Name(), out)
+}
+
+// compiler returns the C compiler command line for p: the --cc template
+// with {os}, {arch} and {target} filled in, or plain cc when p is the
+// local platform and no template was given.
+func (c *buildCache) compiler(p client.Platform) ([]string, error) {
+	if c.cc == "" {
+		if p.OS == runtime.GOOS && p.Arch == runtime.GOARCH {
+			return []string{"cc"}, nil
+		}
+		return nil, fmt.Errorf("no cross compiler for %s; set one with --cc", p)
+	}
+	cc := strings.NewReplacer("{os}", p.OS, "{arch}", p.Arch, "{target}", zigTarget(p)).Replace(c.cc)
+	fields := strings.Fields(cc)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty --cc")
+	}
+	return fields, nil
+}
+
+// zigTarget names p the way zig cc -target and most clang toolchains do,
+// e.g. x86_64-linux-musl.
+func zigTarget(p client.Platform) string {
+	arch := map[string]string{
+		"amd64":   "x86_64",
+		"arm64":   "aarch64",
+		"386":     "x86",
+		"arm":     "arm",
+		"ppc64le": "powerpc64le",
+		"riscv64": "riscv64",
+		"s390x":   "s390x",
+	}[p.Arch]
+	if arch == "" {
+		arch = p.Arch
+	}
+	switch p.OS {
+	case "linux":
+		if p.Arch == "arm" {
+			return arch + "-linux-musleabihf"
+		}
+		return arch + "-linux-musl"
+	case "windows":
+		return arch + "-windows-gnu"
+	case "darwin":
+		return arch + "-macos"
+	}
+	return arch + "-" + p.OS
+}
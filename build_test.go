@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"godev/client"
+)
+
+// keepGoCache pins GOCACHE before XDG_CACHE_HOME moves, so the tests do
+// not build the standard library from scratch.
+func keepGoCache(t *testing.T) {
+	t.Helper()
+	out, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOCACHE", strings.TrimSpace(string(out)))
+}
+
+func TestBuildPicksUpImportedChanges(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain")
+	}
+	keepGoCache(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	mod := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(mod, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/tools\n\ngo 1.21\n")
+	write("tool.go", "package main\n\nimport \"example.com/tools/internal/x\"\n\nfunc main() { println(x.Msg) }\n")
+	write("internal/x/x.go", "package x\n\nconst Msg = \"first\"\n")
+
+	p := client.Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	run := func() string {
+		t.Helper()
+		// Each run of godev has a buildCache of its own.
+		c, err := newBuildCache(filepath.Join(mod, "tool.go"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := c.binary(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command(bin).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", bin, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	if got := run(); got != "first" {
+		t.Fatalf("first build printed %q, want %q", got, "first")
+	}
+	write("internal/x/x.go", "package x\n\nconst Msg = \"second\"\n")
+	if got := run(); got != "second" {
+		t.Errorf("after editing an imported package the binary printed %q, want %q", got, "second")
+	}
+}
+
+func TestBuildIsReproducible(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain")
+	}
+	keepGoCache(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	src := filepath.Join(t.TempDir(), "hello.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := client.Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	var sums []string
+	for i := 0; i < 2; i++ {
+		c, err := newBuildCache(src, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := c.binary(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := fileSHA256(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums = append(sums, sum)
+	}
+	// Hosts' script caches only help when a rebuild is the same file.
+	if sums[0] != sums[1] {
+		t.Errorf("rebuilding an unchanged source gave a different binary")
+	}
+}
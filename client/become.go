@@ -0,0 +1,298 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrBecomeAuth is returned when a privilege escalation tool rejected the
+// password it was given.
+var ErrBecomeAuth = errors.New("become: incorrect password")
+
+// ErrBecomePrompt is returned when a become tool run in a PTY never asked
+// for the password it was going to be given.
+var ErrBecomePrompt = errors.New("become: no password prompt")
+
+// becomePromptTimeout is how long a become tool run in a PTY gets to ask
+// for its password, or the dial timeout if that is longer.
+const becomePromptTimeout = 30 * time.Second
+
+// becomeMethod describes how to drive one privilege escalation tool.
+type becomeMethod struct {
+	// command returns the remote command line that runs script as user
+	// ("" for the tool's default). withPassword says whether a password
+	// will be supplied.
+	command func(script, user string, withPassword bool) string
+	// usePTY is set for tools that only read passwords from a terminal.
+	// The password is then typed into a PTY once prompt shows up in the
+	// output, instead of being sent over stdin up front.
+	usePTY bool
+	prompt string
+	// needsPassword is set for tools with no passwordless mode to fall
+	// back on, which would otherwise sit at their prompt forever.
+	needsPassword bool
+	// failures are messages that mean the password was rejected.
+	failures []string
+}
+
+var becomeMethods = map[string]becomeMethod{
+	"sudo": {
+		command: func(script, user string, withPassword bool) string {
+			cmd := "sudo"
+			if user != "" {
+				cmd += " -u " + shellQuote(user)
+			}
+			if withPassword {
+				return cmd + " -S -p '' sh -c " + shellQuote(script)
+			}
+			return cmd + " -n sh -c " + shellQuote(script)
+		},
+		failures: []string{"Sorry, try again", "incorrect password"},
+	},
+	"doas": {
+		command: func(script, user string, withPassword bool) string {
+			cmd := "doas"
+			if !withPassword {
+				cmd += " -n"
+			}
+			if user != "" {
+				cmd += " -u " + shellQuote(user)
+			}
+			return cmd + " sh -c " + shellQuote(script)
+		},
+		usePTY:   true,
+		prompt:   "password:",
+		failures: []string{"Authentication failed", "Permission denied"},
+	},
+	"su": {
+		command: func(script, user string, withPassword bool) string {
+			if user == "" {
+				user = "root"
+			}
+			return "su - " + shellQuote(user) + " -c " + shellQuote("sh -c "+shellQuote(script))
+		},
+		usePTY:        true,
+		prompt:        "password:",
+		needsPassword: true,
+		failures:      []string{"Authentication failure", "incorrect password", "Sorry"},
+	},
+	"pfexec": {
+		command: func(script, user string, withPassword bool) string {
+			return "pfexec sh -c " + shellQuote(script)
+		},
+	},
+}
+
+// BecomeMethods lists the supported privilege escalation methods.
+func BecomeMethods() []string {
+	names := make([]string, 0, len(becomeMethods))
+	for name := range becomeMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidBecomeMethod reports an error for a method name godev does not know.
+func ValidBecomeMethod(name string) error {
+	if name == "runas" {
+		return fmt.Errorf("become method runas is not supported: it reads its password from the Windows console, which an SSH session does not have")
+	}
+	if _, ok := becomeMethods[name]; !ok {
+		return fmt.Errorf("unknown become method %q (want one of %v)", name, BecomeMethods())
+	}
+	return nil
+}
+
+// becomeMethodFor picks h's method: the inventory's choice, then the run's
+// default, then sudo.
+func becomeMethodFor(h HostInfo, opts Options) (string, becomeMethod) {
+	name := h.BecomeMethod
+	if name == "" {
+		name = opts.BecomeMethod
+	}
+	if name == "" {
+		name = "sudo"
+	}
+	return name, becomeMethods[name]
+}
+
+// execBecome runs script on session with privileges gained through h's
+// become method. The escalated shell first prints a random marker, so
+// anything before it (prompts, tool noise) is dropped from the output and
+// a missing marker tells us escalation itself failed. Scripts get no
+// stdin, so a password the tool did not ask for cannot leak into output.
+func execBecome(session *ssh.Session, h HostInfo, script string, opts Options, start time.Time) Result {
+	name, method := becomeMethodFor(h, opts)
+	if method.command == nil {
+		return failedResult(h.Host, start, ValidBecomeMethod(name))
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	marker := "GODEV-BECOME-" + hex.EncodeToString(nonce)
+
+	wrapped := "echo " + marker + "\nexec </dev/null\n" + script
+	password := strings.TrimSpace(h.SudoPassword)
+	if password == "" && method.needsPassword {
+		return failedResult(h.Host, start, fmt.Errorf("become via %s needs a sudo password in the inventory", name))
+	}
+	cmd := method.command(wrapped, opts.BecomeUser, password != "")
+
+	opts, flush := redactStreams(opts)
+	defer flush()
+
+	var stdout, stderr bytes.Buffer
+	watcher := &becomeWatcher{
+		marker: marker,
+		out:    captureWriter(&stdout, opts.Stdout, opts.SkipCapture),
+	}
+	session.Stdout = watcher
+	session.Stderr = captureWriter(&stderr, opts.Stderr, opts.SkipCapture)
+
+	if method.usePTY {
+		modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.ONLCR: 0}
+		if err := session.RequestPty("xterm", 40, 200, modes); err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("request pty failed: %v", err))
+		}
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("stdin pipe: %v", err))
+		}
+		defer stdin.Close()
+		if password != "" {
+			watcher.prompt = strings.ToLower(method.prompt)
+			watcher.answer = func() { io.WriteString(stdin, password+"\n") }
+		}
+	} else if password != "" {
+		session.Stdin = strings.NewReader(password + "\n")
+	}
+
+	// A prompt that never matches, because it is localized or customized,
+	// would otherwise leave the tool waiting for a password forever.
+	var noPrompt atomic.Bool
+	wait := becomePromptTimeout
+	if opts.Timeout > wait {
+		wait = opts.Timeout
+	}
+	if watcher.answer != nil {
+		timer := time.AfterFunc(wait, func() {
+			if watcher.awaitingPrompt() {
+				noPrompt.Store(true)
+				session.Close()
+			}
+		})
+		defer timer.Stop()
+	}
+
+	err := session.Run(cmd)
+
+	res := Result{
+		Host:      h.Host,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		StartedAt: start,
+		Duration:  time.Since(start),
+	}
+	res.ExitCode, res.Signal = exitStatus(err)
+
+	if !watcher.escalated() {
+		// Nothing ran with privileges, so what was printed came from the
+		// become tool itself.
+		res.Stdout = watcher.before()
+		if noPrompt.Load() {
+			res.Error = fmt.Errorf("%w from %s within %s (waited for %q)", ErrBecomePrompt, name, wait, method.prompt)
+		}
+		noise := res.Stdout + res.Stderr
+		for _, f := range method.failures {
+			if res.Error == nil && strings.Contains(strings.ToLower(noise), strings.ToLower(f)) {
+				res.Error = fmt.Errorf("%w (%s)", ErrBecomeAuth, name)
+				break
+			}
+		}
+		if res.Error == nil && err != nil {
+			res.Error = fmt.Errorf("become via %s failed: %w", name, err)
+		}
+	} else if err != nil {
+		res.Error = fmt.Errorf("ssh error: %w", err)
+	}
+	res.Output = res.Stdout
+	return redactResult(res)
+}
+
+// becomeWatcher sits on a become session's stdout. It answers the
+// password prompt when one is expected and passes through only what comes
+// after the marker.
+type becomeWatcher struct {
+	mu     sync.Mutex
+	marker string
+	prompt string
+	answer func()
+	out    io.Writer
+	pre    bytes.Buffer
+	passed bool
+}
+
+func (w *becomeWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.passed {
+		return w.out.Write(p)
+	}
+	w.pre.Write(p)
+
+	if w.answer != nil && strings.Contains(strings.ToLower(w.pre.String()), w.prompt) {
+		w.answer()
+		w.answer = nil
+	}
+
+	if i := bytes.Index(w.pre.Bytes(), []byte(w.marker)); i >= 0 {
+		rest := w.pre.Bytes()[i+len(w.marker):]
+		rest = bytes.TrimLeft(rest, " \r")
+		rest = bytes.TrimPrefix(rest, []byte("\n"))
+		w.passed = true
+		w.pre.Truncate(i)
+		if len(rest) > 0 {
+			if _, err := w.out.Write(rest); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// awaitingPrompt reports whether the password is still waiting for its
+// prompt to show up.
+func (w *becomeWatcher) awaitingPrompt() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.answer != nil && !w.passed
+}
+
+func (w *becomeWatcher) escalated() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.passed
+}
+
+// before is what was printed before the marker, or everything if the
+// marker never showed up.
+func (w *becomeWatcher) before() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pre.String()
+}
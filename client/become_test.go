@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBecomeCommand(t *testing.T) {
+	tests := []struct {
+		method       string
+		user         string
+		withPassword bool
+		want         string
+	}{
+		{"sudo", "", true, `sudo -S -p '' sh -c 'id'`},
+		{"sudo", "", false, `sudo -n sh -c 'id'`},
+		{"sudo", "postgres", true, `sudo -u 'postgres' -S -p '' sh -c 'id'`},
+		{"sudo", "postgres", false, `sudo -u 'postgres' -n sh -c 'id'`},
+		{"doas", "", true, `doas sh -c 'id'`},
+		{"doas", "", false, `doas -n sh -c 'id'`},
+		{"doas", "postgres", true, `doas -u 'postgres' sh -c 'id'`},
+		{"doas", "postgres", false, `doas -n -u 'postgres' sh -c 'id'`},
+		{"su", "", true, `su - 'root' -c 'sh -c '\''id'\'''`},
+		{"su", "postgres", true, `su - 'postgres' -c 'sh -c '\''id'\'''`},
+		{"pfexec", "", false, `pfexec sh -c 'id'`},
+		{"pfexec", "postgres", true, `pfexec sh -c 'id'`},
+	}
+	for _, tt := range tests {
+		got := becomeMethods[tt.method].command("id", tt.user, tt.withPassword)
+		if got != tt.want {
+			t.Errorf("%s (user %q, password %v) = %s, want %s", tt.method, tt.user, tt.withPassword, got, tt.want)
+		}
+	}
+}
+
+func TestBecomeCommandQuotesScript(t *testing.T) {
+	script := `echo "it's $HOME"`
+	for _, name := range BecomeMethods() {
+		got := becomeMethods[name].command(script, "o'neil", true)
+		if strings.Contains(got, script) {
+			t.Errorf("%s left the script unquoted: %s", name, got)
+		}
+		if name != "pfexec" && !strings.Contains(got, `'o'\''neil'`) {
+			t.Errorf("%s did not quote the user: %s", name, got)
+		}
+	}
+}
+
+func TestBecomeErrorClass(t *testing.T) {
+	for _, err := range []error{ErrBecomeAuth, ErrBecomePrompt} {
+		res := Result{Error: fmt.Errorf("%w (doas)", err), ExitCode: 1}
+		if got := res.ErrorClass(); got != "become" {
+			t.Errorf("%v: ErrorClass() = %q, want become", err, got)
+		}
+	}
+	if errors.Is(ErrBecomePrompt, ErrBecomeAuth) {
+		t.Error("a missing prompt must not read as a wrong password")
+	}
+}
+
+func TestValidBecomeMethod(t *testing.T) {
+	for _, name := range BecomeMethods() {
+		if err := ValidBecomeMethod(name); err != nil {
+			t.Errorf("ValidBecomeMethod(%q) = %v", name, err)
+		}
+	}
+	if err := ValidBecomeMethod("runas"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("ValidBecomeMethod(runas) = %v, want it reported as unsupported", err)
+	}
+	if err := ValidBecomeMethod("sudoo"); err == nil {
+		t.Error("ValidBecomeMethod(sudoo) = nil")
+	}
+}
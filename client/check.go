@@ -0,0 +1,157 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// checkReport collects what a run with opts.Check would have done on one
+// host, copying each line to opts.Stdout as it goes.
+type checkReport struct {
+	out    strings.Builder
+	stdout io.Writer
+}
+
+func newCheckReport(opts Options) *checkReport {
+	return &checkReport{stdout: opts.Stdout}
+}
+
+func (c *checkReport) printf(format string, args ...any) {
+	line := Redact(fmt.Sprintf(format, args...))
+	c.out.WriteString(line)
+	if c.stdout != nil {
+		io.WriteString(c.stdout, line)
+	}
+}
+
+// result is the Result for the checked host, with status as its Status.
+func (c *checkReport) result(h HostInfo, start time.Time, status string) Result {
+	return redactResult(Result{
+		Host:      h.Host,
+		Stdout:    c.out.String(),
+		Output:    c.out.String(),
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Status:    status,
+	})
+}
+
+// checkAccess reports that conn got in and, with opts.Become, tries h's
+// become method with a command that changes nothing, so a wrong password
+// shows up before the real run.
+func (c *checkReport) checkAccess(conn *ssh.Client, h HostInfo, opts Options) error {
+	c.printf("connected as %s@%s:%d\n", h.User, h.Host, h.Port)
+	if !opts.Become {
+		return nil
+	}
+	quiet := opts
+	quiet.Stdout, quiet.Stderr, quiet.SkipCapture = nil, nil, false
+	name, _ := becomeMethodFor(h, opts)
+	if res := execOn(conn, h, "true", "", quiet); res.Error != nil {
+		return res.Error
+	}
+	if opts.BecomeUser != "" {
+		name += " as " + opts.BecomeUser
+	}
+	c.printf("become via %s works\n", name)
+	return nil
+}
+
+// guards shows the --unless and --only-if commands that would decide
+// whether h is run, which check mode does not run.
+func (c *checkReport) guards(h HostInfo, opts Options) {
+	if opts.Unless != "" {
+		c.printf("would check --unless:\n%s", indent(commandLine(h, opts.Unless, opts)))
+	}
+	if opts.OnlyIf != "" {
+		c.printf("would check --only-if:\n%s", indent(commandLine(h, opts.OnlyIf, opts)))
+	}
+}
+
+// commandLine is the command line that would be sent to h to run cmd,
+// wrapped in h's become method with opts.Become. The password itself is
+// never part of it.
+func commandLine(h HostInfo, cmd string, opts Options) string {
+	if !opts.Become {
+		return cmd
+	}
+	_, method := becomeMethodFor(h, opts)
+	if method.command == nil {
+		return cmd
+	}
+	return method.command(cmd, opts.BecomeUser, strings.TrimSpace(h.SudoPassword) != "")
+}
+
+// indent prefixes every line of s for showing a command under its heading.
+func indent(s string) string {
+	s = strings.TrimRight(s, "\n")
+	return "    " + strings.ReplaceAll(s, "\n", "\n    ") + "\n"
+}
+
+// checkScript is RunScript and RunSteps under opts.Check: it shows each
+// command line instead of running it.
+func checkScript(conn *ssh.Client, h HostInfo, scripts []string, opts Options, start time.Time) Result {
+	c := newCheckReport(opts)
+	if err := c.checkAccess(conn, h, opts); err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	c.guards(h, opts)
+	for i, script := range scripts {
+		if len(scripts) > 1 {
+			c.printf("would run step %d:\n", i+1)
+		} else {
+			c.printf("would run:\n")
+		}
+		c.printf("%s", indent(commandLine(h, script, opts)))
+	}
+	return c.result(h, start, "")
+}
+
+// checkUpload is runUploaded under opts.Check: it shows where the script
+// would go and how it would be run, without creating anything.
+func checkUpload(conn *ssh.Client, h HostInfo, scriptPath string, windows bool, opts Options, start time.Time) Result {
+	c := newCheckReport(opts)
+	if err := c.checkAccess(conn, h, opts); err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	c.guards(h, opts)
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+
+	tmp := remoteTmp{dir: "${TMPDIR:-/tmp}/godev.XXXXXXXXXX", windows: windows}
+	switch {
+	case windows && opts.RemoteTmp != "":
+		tmp.dir = opts.RemoteTmp + `\godev-GUID`
+	case windows:
+		tmp.dir = `%TEMP%\godev-GUID`
+	case opts.RemoteTmp != "":
+		tmp.dir = strings.TrimRight(opts.RemoteTmp, "/") + "/godev.XXXXXXXXXX"
+	}
+	remote := tmp.path(filepath.Base(scriptPath))
+	sum, err := cachedLocalSHA256(scriptPath)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	cached, err := cacheHas(conn, h.Host, sum, filepath.Base(scriptPath), windows)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	if cached {
+		c.printf("%s is cached, would not upload it; would copy it to %s\n", scriptPath, remote)
+	} else {
+		c.printf("would upload %s (%d bytes) to %s\n", scriptPath, info.Size(), remote)
+	}
+	c.printf("would run:\n%s", indent(commandLine(h, tmp.command(remote, interpreterFor(h, opts, scriptPath, windows), opts), opts)))
+	if opts.KeepRemote {
+		c.printf("would keep %s afterwards\n", tmp.dir)
+	}
+	return c.result(h, start, "")
+}
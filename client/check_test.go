@@ -0,0 +1,15 @@
+package client
+
+import "testing"
+
+func TestCheckShowsGuardCommands(t *testing.T) {
+	h := HostInfo{Host: "web1", SudoPassword: "pw"}
+	opts := Options{Check: true, Become: true, Unless: "test -f /done", OnlyIf: "grep -q x /etc/x"}
+	c := newCheckReport(Options{})
+	c.guards(h, opts)
+	want := "would check --unless:\n    sudo -S -p '' sh -c 'test -f /done'\n" +
+		"would check --only-if:\n    sudo -S -p '' sh -c 'grep -q x /etc/x'\n"
+	if got := c.out.String(); got != want {
+		t.Errorf("check output:\n%s\nwant:\n%s", got, want)
+	}
+}
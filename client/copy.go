@@ -0,0 +1,485 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// CopySpec says how Copy installs files. Mode is an octal mode such as
+// "0644" for every file copied; without it existing files keep their mode
+// and new ones get the local file's. Owner and Group are handed to chown,
+// so they need opts.Become unless the SSH user already owns the files.
+// Backup keeps the previous content of a file Copy changes next to it, as
+// <file>.<timestamp>.bak. Template renders every file as a text/template
+// with the host's TemplateData first, and only the result is uploaded; a
+// ".tmpl" suffix is dropped from names Copy picks itself. Diff reports a
+// unified diff of every file Copy is about to change against what the host
+// has now.
+type CopySpec struct {
+	Mode     string
+	Owner    string
+	Group    string
+	Backup   bool
+	Template bool
+	Diff     bool
+}
+
+// copyItem is one file or directory Copy puts on a host.
+type copyItem struct {
+	local  string
+	remote string
+	dir    bool
+	perm   fs.FileMode
+	sum    string
+}
+
+// installScript defines the shell functions the copy installer runs as,
+// one call per directory and file. inst installs a staged file (or, with
+// no staged file, only fixes mode and owner) and prints "changed PATH" or
+// "unchanged PATH". New content is written next to the target and renamed
+// over it, and an existing file lends the new one its mode and owner first.
+const installScript = `perm() { ls -ldn "$1" 2>/dev/null | awk '{print $1, $3, $4}'; }
+mkd() {
+  if [ ! -d "$1" ]; then mkdir -p "$1" || return 1; echo "changed $1/"; fi
+  if [ -n "$2" ]; then chown "$2" "$1" || return 1; fi
+}
+inst() {
+  s=$1; d=$2; m=$3; o=$4; dm=$5; n="$d.godev-new"
+  before=$(perm "$d")
+  state=unchanged
+  if [ -n "$s" ]; then
+    mkdir -p "$(dirname "$d")" || return 1
+    if [ -f "$d" ]; then
+      if [ -n "$BACKUP" ]; then cp -p "$d" "$d.$BACKUP" || return 1; fi
+      { cp -p "$d" "$n" && cat "$s" > "$n"; } || { rm -f "$n"; return 1; }
+    else
+      cp "$s" "$n" || { rm -f "$n"; return 1; }
+      [ -n "$m" ] || m=$dm
+    fi
+    if [ -n "$m" ]; then chmod "$m" "$n" || { rm -f "$n"; return 1; }; fi
+    if [ -n "$o" ]; then chown "$o" "$n" || { rm -f "$n"; return 1; }; fi
+    mv -f "$n" "$d" || { rm -f "$n"; return 1; }
+    state=changed
+  else
+    if [ -n "$m" ]; then chmod "$m" "$d" || return 1; fi
+    if [ -n "$o" ]; then chown "$o" "$d" || return 1; fi
+    [ "$(perm "$d")" = "$before" ] || state=changed
+  fi
+  echo "$state $d"
+}
+`
+
+// Copy uploads src, a file or a directory tree, to dest on h. A dest that
+// ends in "/" or is an existing directory receives src under its own name,
+// as does a src directory without a trailing "/"; otherwise dest is the
+// new name. Files whose SHA-256 already matches are not sent, and the
+// Result's Status is "changed" when anything on the host had to change
+// and "unchanged" otherwise. Its Stdout lists each path and what happened
+// to it. With opts.Become the files are installed through h's become
+// method, so they can go where the SSH user cannot write.
+func Copy(h HostInfo, src, dest string, spec CopySpec, opts Options) Result {
+	start := time.Now()
+
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	defer conn.Close()
+
+	platform, err := probePlatform(conn, h)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	windows := platform.OS == "windows"
+	if windows {
+		dest = strings.ReplaceAll(dest, `\`, "/")
+	}
+
+	items, err := copyPlan(conn, src, dest, spec.Template)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	if spec.Template {
+		facts, err := gatherFacts(conn, h, platform)
+		if err != nil {
+			return failedResult(h.Host, start, err)
+		}
+		dir, err := renderTemplates(items, TemplateData{Host: h.Host, User: h.User, Port: h.Port, Vars: h.Vars, Facts: facts})
+		defer os.RemoveAll(dir)
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("render: %w", err))
+		}
+	}
+	for i := range items {
+		if items[i].dir {
+			continue
+		}
+		if items[i].sum, err = localSHA256(items[i].local); err != nil {
+			return failedResult(h.Host, start, err)
+		}
+	}
+
+	if windows {
+		return timedFrom(copyWindows(conn, h, items, spec, opts), start)
+	}
+	return timedFrom(copyUnix(conn, h, items, spec, opts), start)
+}
+
+// copyPlan lists what copying src to dest involves. With template, file
+// names it picks lose a ".tmpl" suffix.
+func copyPlan(conn *ssh.Client, src, dest string, template bool) ([]copyItem, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	destIsDir := strings.HasSuffix(dest, "/")
+	if !destIsDir {
+		if client, err := sftp.NewClient(conn); err == nil {
+			if fi, err := client.Stat(dest); err == nil && fi.IsDir() {
+				destIsDir = true
+			}
+			client.Close()
+		}
+	}
+
+	name := func(remote string) string {
+		if template {
+			return strings.TrimSuffix(remote, ".tmpl")
+		}
+		return remote
+	}
+
+	var items []copyItem
+	if !info.IsDir() {
+		target := dest
+		if destIsDir {
+			target = name(path.Join(dest, filepath.Base(src)))
+		}
+		items = append(items, copyItem{local: src, remote: target, perm: info.Mode().Perm()})
+	} else {
+		root := path.Clean(dest)
+		if destIsDir && !strings.HasSuffix(src, "/") && !strings.HasSuffix(src, string(filepath.Separator)) {
+			root = path.Join(root, filepath.Base(filepath.Clean(src)))
+		}
+		err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			remote := path.Join(root, filepath.ToSlash(rel))
+			fi, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			switch {
+			case fi.IsDir():
+				items = append(items, copyItem{local: p, remote: remote, dir: true, perm: fi.Mode().Perm()})
+			case fi.Mode().IsRegular():
+				items = append(items, copyItem{local: p, remote: name(remote), perm: fi.Mode().Perm()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// copyUnix checksums what is on the host, stages the files that differ in
+// a private temp dir and installs everything with installScript.
+func copyUnix(conn *ssh.Client, h HostInfo, items []copyItem, spec CopySpec, opts Options) (res Result) {
+	start := time.Now()
+
+	// Reading what is there may need the same privileges as writing it.
+	quiet := opts
+	quiet.Stdout, quiet.Stderr, quiet.SkipCapture = nil, nil, false
+
+	var files, dirs []copyItem
+	var check strings.Builder
+	check.WriteString(remoteHash + "for f in")
+	for _, it := range items {
+		if it.dir {
+			dirs = append(dirs, it)
+		} else {
+			files = append(files, it)
+			check.WriteString(" " + shellQuote(it.remote))
+		}
+	}
+	check.WriteString(`; do if [ -f "$f" ]; then h < "$f"; else echo -; fi; done`)
+	// Installing creates missing directories as it goes, so only --check
+	// needs to know which those are.
+	if !opts.Check {
+		dirs = nil
+	}
+	if len(dirs) > 0 {
+		check.WriteString("; for d in")
+		for _, it := range dirs {
+			check.WriteString(" " + shellQuote(it.remote))
+		}
+		check.WriteString(`; do if [ -d "$d" ]; then echo d; else echo -; fi; done`)
+	}
+
+	var sums, dirStates []string
+	if len(files)+len(dirs) > 0 {
+		res := execOn(conn, h, check.String(), "", quiet)
+		if res.Error != nil {
+			res.Error = fmt.Errorf("check remote files: %w", res.Error)
+			return res
+		}
+		sums = strings.Fields(res.Stdout)
+		if len(sums) != len(files)+len(dirs) {
+			return failedResult(h.Host, start, fmt.Errorf("check remote files: got %d answers for %d paths", len(sums), len(files)+len(dirs)))
+		}
+		sums, dirStates = sums[:len(files)], sums[len(files):]
+	}
+
+	var diffs strings.Builder
+	var tmp *remoteTmp
+	staged := map[string]string{}
+	for i, f := range files {
+		if sums[i] == f.sum {
+			continue
+		}
+		if spec.Diff {
+			current := ""
+			if sums[i] != "-" {
+				res := execOn(conn, h, "head -c "+strconv.Itoa(maxCopyDiff+1)+" "+shellQuote(f.remote), "", quiet)
+				if res.Error != nil {
+					res.Error = fmt.Errorf("read %s: %w", f.remote, res.Error)
+					return res
+				}
+				current = res.Stdout
+			}
+			diffs.WriteString(copyDiff(f, current, sums[i] != "-"))
+		}
+		if opts.Check {
+			continue
+		}
+		if tmp == nil {
+			t, err := makeRemoteTmp(conn, h, opts, false)
+			if err != nil {
+				return failedResult(h.Host, start, err)
+			}
+			defer t.cleanup(conn, h, opts, &res)
+			tmp = &t
+		}
+		remote := tmp.path(strconv.Itoa(i))
+		fromCache := false
+		if opts.FromCache {
+			if cached, ok, err := cacheLookup(conn, h.Host, f.sum, filepath.Base(f.local), false); err == nil && ok {
+				fromCache = copyFromCache(conn, h.Host, cached, remote, false) == nil
+			}
+		}
+		if !fromCache {
+			if _, err := syncFile(conn, h.Host, f.local, remote, false, false); err != nil {
+				return failedResult(h.Host, start, fmt.Errorf("upload %s: %w", f.local, err))
+			}
+		}
+		if opts.Become {
+			if err := tmp.grant(conn, h, opts.BecomeUser, remote); err != nil {
+				return failedResult(h.Host, start, err)
+			}
+		}
+		staged[f.remote] = remote
+	}
+
+	if opts.Check {
+		c := newCheckReport(opts)
+		if err := c.checkAccess(conn, h, opts); err != nil {
+			return failedResult(h.Host, start, err)
+		}
+		c.printf("%s", diffs.String())
+		status := "unchanged"
+		for i, d := range dirs {
+			if dirStates[i] != "d" {
+				c.printf("would create %s/\n", d.remote)
+				status = "changed"
+			}
+		}
+		for i, f := range files {
+			if sums[i] == f.sum {
+				c.printf("unchanged %s\n", f.remote)
+			} else {
+				c.printf("would change %s\n", f.remote)
+				status = "changed"
+			}
+		}
+		return c.result(h, start, status)
+	}
+
+	owner := spec.Owner
+	if spec.Group != "" {
+		owner += ":" + spec.Group
+	}
+	var script strings.Builder
+	script.WriteString(installScript)
+	backup := ""
+	if spec.Backup {
+		backup = time.Now().UTC().Format("20060102T150405Z") + ".bak"
+	}
+	script.WriteString("BACKUP=" + shellQuote(backup) + "\n")
+	for _, it := range items {
+		if it.dir {
+			script.WriteString("mkd " + shellQuote(it.remote) + " " + shellQuote(owner) + " || exit 1\n")
+			continue
+		}
+		script.WriteString("inst " + shellQuote(staged[it.remote]) + " " + shellQuote(it.remote) + " " +
+			shellQuote(spec.Mode) + " " + shellQuote(owner) + " " + fmt.Sprintf("%04o", it.perm) + " || exit 1\n")
+	}
+
+	if diffs.Len() > 0 && opts.Stdout != nil {
+		io.WriteString(opts.Stdout, Redact(diffs.String()))
+	}
+	res = execOn(conn, h, script.String(), "", opts)
+	res.Status = copyStatus(res.Stdout)
+	if !opts.SkipCapture {
+		res.Stdout = Redact(diffs.String()) + res.Stdout
+		res.Output = Redact(diffs.String()) + res.Output
+	}
+	return res
+}
+
+// maxCopyDiff is the largest file Copy shows a diff for.
+const maxCopyDiff = 1 << 20
+
+// copyDiff returns a unified diff from current, f's content on the host,
+// to what it will be. Secrets are masked on both sides first so they do
+// not show up as changes once the output is masked.
+func copyDiff(f copyItem, current string, exists bool) string {
+	data, err := os.ReadFile(f.local)
+	if err != nil {
+		return fmt.Sprintf("cannot diff %s: %v\n", f.remote, err)
+	}
+	if len(data) > maxCopyDiff || len(current) > maxCopyDiff {
+		return fmt.Sprintf("diff of %s not shown: larger than %d bytes\n", f.remote, maxCopyDiff)
+	}
+	if bytes.IndexByte(data, 0) >= 0 || strings.IndexByte(current, 0) >= 0 {
+		return fmt.Sprintf("binary file %s differs\n", f.remote)
+	}
+	from := f.remote
+	if !exists {
+		from = "/dev/null"
+	}
+	return UnifiedDiff(Redact(current), Redact(string(data)), from, f.remote)
+}
+
+// copyWindows copies items over SFTP, backing up with PowerShell. Windows
+// hosts have no modes or chown, so spec.Mode, Owner and Group are refused.
+func copyWindows(conn *ssh.Client, h HostInfo, items []copyItem, spec CopySpec, opts Options) Result {
+	start := time.Now()
+	if spec.Mode != "" || spec.Owner != "" || spec.Group != "" {
+		return failedResult(h.Host, start, fmt.Errorf("mode, owner and group cannot be set on Windows hosts"))
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return failedResult(h.Host, start, fmt.Errorf("start sftp: %v", err))
+	}
+	defer client.Close()
+
+	var out strings.Builder
+	report := func(state, p string) {
+		line := state + " " + p + "\n"
+		out.WriteString(line)
+		if opts.Stdout != nil {
+			io.WriteString(opts.Stdout, line)
+		}
+	}
+
+	if opts.Check {
+		report("connected as", fmt.Sprintf("%s@%s:%d", h.User, h.Host, h.Port))
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z") + ".bak"
+	for _, it := range items {
+		if it.dir {
+			if _, err := client.Stat(it.remote); err != nil {
+				if opts.Check {
+					report("would create", it.remote+"/")
+					continue
+				}
+				if err := client.MkdirAll(it.remote); err != nil {
+					return failedResult(h.Host, start, fmt.Errorf("create %s: %v", it.remote, err))
+				}
+				report("changed", it.remote+"/")
+			}
+			continue
+		}
+
+		sum := remoteSHA256(conn, h.Host, it.remote, true)
+		if sum == it.sum {
+			report("unchanged", it.remote)
+			continue
+		}
+		if spec.Diff {
+			current := ""
+			if f, err := client.Open(it.remote); err == nil {
+				data, err := io.ReadAll(io.LimitReader(f, maxCopyDiff+1))
+				f.Close()
+				if err != nil {
+					return failedResult(h.Host, start, fmt.Errorf("read %s: %v", it.remote, err))
+				}
+				current = string(data)
+			}
+			diff := Redact(copyDiff(it, current, sum != ""))
+			out.WriteString(diff)
+			if opts.Stdout != nil {
+				io.WriteString(opts.Stdout, diff)
+			}
+		}
+		if opts.Check {
+			report("would change", it.remote)
+			continue
+		}
+		if spec.Backup && sum != "" {
+			q := strings.ReplaceAll(it.remote, "'", "''")
+			res := runOn(conn, h.Host, `powershell -NoProfile -NonInteractive -Command "Copy-Item -LiteralPath '`+q+`' -Destination '`+q+"."+stamp+`'"`)
+			if res.Error != nil {
+				return failedResult(h.Host, start, fmt.Errorf("back up %s: %w", it.remote, res.Error))
+			}
+		}
+		if err := client.MkdirAll(path.Dir(it.remote)); err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("create %s: %v", path.Dir(it.remote), err))
+		}
+		if _, err := syncFile(conn, h.Host, it.local, it.remote, true, false); err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("upload %s: %w", it.local, err))
+		}
+		report("changed", it.remote)
+	}
+
+	res := Result{
+		Host:      h.Host,
+		Stdout:    out.String(),
+		Output:    out.String(),
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Status:    copyStatus(out.String()),
+	}
+	return redactResult(res)
+}
+
+// copyStatus is "changed" when any line of the installer's report says
+// something changed or, under opts.Check, would.
+func copyStatus(report string) string {
+	for _, line := range strings.Split(report, "\n") {
+		if strings.HasPrefix(line, "changed ") || strings.HasPrefix(line, "would ") {
+			return "changed"
+		}
+	}
+	return "unchanged"
+}
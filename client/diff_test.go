@@ -0,0 +1,51 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines 1 to 12, with the lines in repl replaced.
+func numbered(repl map[int]string) string {
+	var sb strings.Builder
+	for i := 1; i <= 12; i++ {
+		if r, ok := repl[i]; ok {
+			sb.WriteString(r + "\n")
+			continue
+		}
+		sb.WriteString(strconv.Itoa(i) + "\n")
+	}
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name, a, b, want string
+	}{
+		{"equal", "x\ny\n", "x\ny\n", ""},
+		{"all removed", "x\n", "", "@@ -1 +0,0 @@\n-x\n"},
+		{"all added", "", "x\n", "@@ -0,0 +1 @@\n+x\n"},
+		{"appended", "x\n", "x\ny\n", "@@ -1 +1,2 @@\n x\n+y\n"},
+		{
+			"far apart changes get their own hunks",
+			numbered(nil), numbered(map[int]string{2: "two", 11: "eleven"}),
+			"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+eleven\n 12\n",
+		},
+		{
+			"close changes share a hunk",
+			numbered(nil), numbered(map[int]string{5: "five", 8: "eight"}),
+			"@@ -2,10 +2,10 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n-8\n+eight\n 9\n 10\n 11\n",
+		},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want != "" {
+			want = "--- old\n+++ new\n" + want
+		}
+		if got := UnifiedDiff(tt.a, tt.b, "old", "new"); got != want {
+			t.Errorf("%s: UnifiedDiff =\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrorClass sorts a failed Result into a coarse category that automation
+// can switch on without parsing error strings: "become", "exit", "signal",
+// "timeout", "connect", "auth", "hostkey", "local" or "other". It returns
+// "" when the Result holds no error.
+func (r Result) ErrorClass() string {
+	if r.Error == nil {
+		return ""
+	}
+	if errors.Is(r.Error, ErrBecomeAuth) || errors.Is(r.Error, ErrBecomePrompt) {
+		return "become"
+	}
+	if r.Signal != "" {
+		return "signal"
+	}
+	if r.ExitCode > 0 {
+		return "exit"
+	}
+
+	var netErr net.Error
+	if errors.As(r.Error, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(r.Error, &opErr) {
+		return "connect"
+	}
+	var keyErr *knownhosts.KeyError
+	if errors.As(r.Error, &keyErr) {
+		return "hostkey"
+	}
+	var missing *ssh.ExitMissingError
+	if errors.As(r.Error, &missing) {
+		return "exit"
+	}
+	var pathErr *fs.PathError
+	if errors.As(r.Error, &pathErr) {
+		return "local"
+	}
+
+	msg := r.Error.Error()
+	switch {
+	case strings.Contains(msg, "unable to authenticate"), strings.Contains(msg, "no authentication methods"):
+		return "auth"
+	case strings.Contains(msg, "knownhosts"), strings.Contains(msg, "host key"):
+		return "hostkey"
+	}
+	return "other"
+}
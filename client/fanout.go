@@ -0,0 +1,226 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	skeemakh "github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fanoutNode is one host in a Distribute tree.
+type fanoutNode struct {
+	h    HostInfo
+	done chan struct{}
+	// cached is where the host keeps the file once it has it, and windows
+	// says whether it can pass it on.
+	cached  string
+	windows bool
+	err     error
+	// hopErr is why the host was not sent the file by another host.
+	hopErr error
+}
+
+// Distribute loads the file at path into the script cache of every host
+// in hosts, so the uploads that follow find it there instead of sending it
+// from this machine again. This machine only sends it to the first fanout
+// hosts; every host that has it then sends it on to up to fanout more, over
+// SSH from that host, authenticated by forwarding the local SSH agent to
+// it. Each receiving host checks the file's SHA-256 before putting it in
+// its cache. A host that could not be reached that way, because its
+// sender failed or the hop itself did, gets the file straight from this
+// machine instead, so the tree keeps growing past it. The returned errs,
+// one per host, say which hosts still do not have the file; the uploads
+// that follow will send it to those as usual. hopErrs, also one per host,
+// say why a host that did get the file had to be sent it directly.
+//
+// Forwarding the agent means every sender is trusted with it while its
+// hops run: root on a sender can use the agent to log in anywhere the
+// agent's keys are accepted. Only use it on fleets whose hosts are trusted
+// as much as this machine. Senders do check receivers against the host
+// keys this machine has in its known_hosts, so a hop never goes to a host
+// this machine does not know.
+func Distribute(hosts []HostInfo, path string, fanout int, opts Options) (errs, hopErrs []error) {
+	errs = make([]error, len(hosts))
+	hopErrs = make([]error, len(hosts))
+	if len(hosts) == 0 || fanout < 1 {
+		return errs, hopErrs
+	}
+	sum, err := cachedLocalSHA256(path)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs, hopErrs
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	name := filepath.Base(path)
+
+	nodes := make([]*fanoutNode, len(hosts))
+	for i, h := range hosts {
+		nodes[i] = &fanoutNode{h: h, done: make(chan struct{})}
+	}
+
+	// Direct uploads all leave through this machine's uplink, so only
+	// fanout of them run at once.
+	direct := make(chan struct{}, fanout)
+
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *fanoutNode) {
+			defer wg.Done()
+			defer close(n.done)
+
+			var hopErr error
+			if i >= fanout && sock != "" {
+				parent := nodes[(i-fanout)/fanout]
+				<-parent.done
+				switch {
+				case parent.err != nil:
+					hopErr = fmt.Errorf("sender %s does not have it", parent.h.Host)
+				case parent.windows:
+					hopErr = fmt.Errorf("sender %s is a Windows host", parent.h.Host)
+				default:
+					n.cached, hopErr = fanoutHop(parent, n.h, sum, name, sock, opts)
+					if hopErr == nil {
+						return
+					}
+				}
+			}
+
+			direct <- struct{}{}
+			n.cached, n.windows, n.err = fanoutDirect(n.h, path, name, opts)
+			<-direct
+			if n.err == nil {
+				n.hopErr = hopErr
+			}
+		}(i, n)
+	}
+	wg.Wait()
+
+	for i, n := range nodes {
+		errs[i], hopErrs[i] = n.err, n.hopErr
+	}
+	return errs, hopErrs
+}
+
+// fanoutDirect sends path from this machine into h's script cache.
+func fanoutDirect(h HostInfo, path, name string, opts Options) (cached string, windows bool, err error) {
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return "", false, err
+	}
+	defer conn.Close()
+
+	platform, err := probePlatform(conn, h)
+	if err != nil {
+		return "", false, err
+	}
+	windows = platform.OS == "windows"
+	cached, err = cacheScript(conn, h.Host, path, name, windows)
+	return cached, windows, err
+}
+
+// fanoutHop has from send its cached copy to h with ssh, using this
+// machine's agent, which is forwarded to from for the purpose. h writes it
+// next to its cache entry and only moves it into place once its SHA-256
+// matches sum, then prints where it went. from checks h's host key against
+// the lines this machine's known_hosts has for h and nothing else.
+func fanoutHop(from *fanoutNode, h HostInfo, sum, name, sock string, opts Options) (string, error) {
+	pinned, err := pinnedKnownHosts(h)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := connectClient(from.h.User, from.h.Password, from.h.Host, from.h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := agent.ForwardToRemote(conn, sock); err != nil {
+		return "", fmt.Errorf("forward agent: %v", err)
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("new session: %v", err)
+	}
+	defer session.Close()
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return "", fmt.Errorf("forward agent: %v", err)
+	}
+
+	receive := remoteHash + `umask 077; d="` + unixCacheDir + `/` + sum + `" && mkdir -p "$d" && t="$d/.godev-recv.$$" && ` +
+		`cat > "$t" && [ "$(h < "$t")" = ` + sum + ` ] && mv -f "$t" "$d"/` + shellQuote(name) + ` && echo "$d"/` + shellQuote(name) +
+		` || { rm -f "$t"; exit 1; }`
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	user := h.User
+	if user == "" {
+		user = from.h.User
+	}
+	// from may only talk to h if h shows the key this machine has for it
+	// in its own known_hosts.
+	cmd := `k=$(mktemp) || exit 1; printf '%s\n'`
+	for _, line := range pinned {
+		cmd += " " + shellQuote(line)
+	}
+	cmd += ` > "$k"; ssh -o BatchMode=yes -o StrictHostKeyChecking=yes` +
+		` -o UserKnownHostsFile="$k" -o GlobalKnownHostsFile=/dev/null` +
+		" -o ConnectTimeout=" + strconv.Itoa(int(timeout.Seconds())) +
+		" -p " + strconv.Itoa(h.Port) + " -l " + shellQuote(user) + " " + shellQuote(h.Host) +
+		" " + shellQuote(receive) + " < " + shellQuote(from.cached) +
+		`; rc=$?; rm -f "$k"; exit $rc`
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(cmd); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("from %s: %s", from.h.Host, msg)
+		}
+		return "", fmt.Errorf("from %s: %v", from.h.Host, err)
+	}
+	cached := strings.TrimSpace(stdout.String())
+	if !strings.HasPrefix(cached, "/") {
+		return "", fmt.Errorf("from %s: receiver printed %q", from.h.Host, cached)
+	}
+	return cached, nil
+}
+
+// pinnedKnownHosts returns the known_hosts lines this machine has for h,
+// for the sender of a hop to check h against. A host this machine does not
+// know cannot be reached by a hop.
+func pinnedKnownHosts(h HostInfo) ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	kh, err := skeemakh.NewDB(filepath.Join(homeDir, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts DB: %w", err)
+	}
+	addr := net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+	var lines []string
+	for _, key := range kh.HostKeys(addr) {
+		line := skeemakh.Line([]string{addr}, key)
+		if key.Cert {
+			line = "@cert-authority " + line
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s is not in known_hosts, so its key cannot be pinned for the hop", addr)
+	}
+	return lines, nil
+}
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// FetchSpec says how Fetch pulls files. Compress has the host gzip each
+// file on the way out, which helps with logs over slow links. MaxSize, when
+// above zero, is the most Fetch will pull from one host in total; a host
+// with more than that matching fails before anything is downloaded.
+type FetchSpec struct {
+	Compress bool
+	MaxSize  int64
+}
+
+// fetchItem is one remote file Fetch pulls.
+type fetchItem struct {
+	remote string
+	local  string
+	size   int64
+	mtime  time.Time
+	perm   os.FileMode
+	sum    string
+}
+
+// Fetch pulls remote, a file, a directory tree or a glob pattern, from h
+// into dir/<name>/, where name is h's LocalName and every file keeps its
+// full remote path: /var/log/app.log lands in dir/<name>/var/log/app.log.
+// A symlink named by remote is followed; symlinks found inside a tree are
+// skipped and reported as such. Each download is
+// checked against the file's SHA-256 on the host, and files whose local
+// copy already matches are not downloaded again. The Result's Status is
+// "changed" when anything was downloaded and "unchanged" otherwise, and
+// its Stdout lists each local file and what happened to it. Files are
+// read as the SSH user.
+func Fetch(h HostInfo, remote, dir string, spec FetchSpec, opts Options) Result {
+	start := time.Now()
+
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	defer conn.Close()
+
+	platform, err := probePlatform(conn, h)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	windows := platform.OS == "windows"
+	if windows {
+		if spec.Compress {
+			return failedResult(h.Host, start, fmt.Errorf("compression is not supported on Windows hosts"))
+		}
+		remote = strings.ReplaceAll(remote, `\`, "/")
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return failedResult(h.Host, start, fmt.Errorf("start sftp: %v", err))
+	}
+	defer client.Close()
+
+	items, links, err := fetchPlan(client, remote, fetchHostDir(dir, h))
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	if len(items) == 0 && len(links) == 0 {
+		return failedResult(h.Host, start, fmt.Errorf("%s: no such file", remote))
+	}
+	if spec.MaxSize > 0 {
+		var total int64
+		for _, it := range items {
+			total += it.size
+		}
+		if total > spec.MaxSize {
+			return failedResult(h.Host, start, fmt.Errorf("%d bytes in %d files is over the %d byte limit", total, len(items), spec.MaxSize))
+		}
+	}
+
+	if err := fetchSums(conn, h.Host, items, windows); err != nil {
+		return failedResult(h.Host, start, err)
+	}
+
+	var out strings.Builder
+	report := func(state, p string) {
+		line := state + " " + p + "\n"
+		out.WriteString(line)
+		if opts.Stdout != nil {
+			io.WriteString(opts.Stdout, line)
+		}
+	}
+
+	if opts.Check {
+		report("connected as", fmt.Sprintf("%s@%s:%d", h.User, h.Host, h.Port))
+	}
+	for _, l := range links {
+		report("skipped symlink", l)
+	}
+	for _, it := range items {
+		if sum, err := localSHA256(it.local); err == nil && sum == it.sum {
+			report("unchanged", it.local)
+			continue
+		}
+		if opts.Check {
+			report("would fetch", it.remote+" to "+it.local)
+			continue
+		}
+		if err := fetchFile(conn, client, h.Host, it, spec.Compress, windows); err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("fetch %s: %w", it.remote, err))
+		}
+		report("changed", it.local)
+	}
+
+	res := Result{
+		Host:      h.Host,
+		Stdout:    out.String(),
+		Output:    out.String(),
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Status:    copyStatus(out.String()),
+	}
+	return redactResult(res)
+}
+
+// fetchPlan lists the regular files remote names on client and where each
+// goes under dir, plus the symlinks it found and skipped. A root that is a
+// symlink is followed, as cp and scp do, but its files keep the path it
+// was named by.
+func fetchPlan(client *sftp.Client, remote, dir string) (items []fetchItem, links []string, err error) {
+	var roots []string
+	if strings.ContainsAny(remote, "*?[") {
+		matches, err := client.Glob(remote)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", remote, err)
+		}
+		roots = matches
+	} else {
+		roots = []string{remote}
+	}
+
+	seen := map[string]bool{}
+	for _, root := range roots {
+		walkRoot, err := followLinks(client, root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", root, err)
+		}
+		walker := client.Walk(walkRoot)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", walker.Path(), err)
+			}
+			named := root + strings.TrimPrefix(walker.Path(), walkRoot)
+			fi := walker.Stat()
+			if fi.Mode()&os.ModeSymlink != 0 {
+				links = append(links, named)
+				continue
+			}
+			if !fi.Mode().IsRegular() || seen[walker.Path()] {
+				continue
+			}
+			seen[walker.Path()] = true
+			items = append(items, fetchItem{
+				remote: walker.Path(),
+				local:  fetchLocalPath(dir, named),
+				size:   fi.Size(),
+				mtime:  fi.ModTime(),
+				perm:   fi.Mode().Perm(),
+			})
+		}
+	}
+	return items, links, nil
+}
+
+// followLinks returns where p ends up once every symlink it is, and the
+// symlinks those point to, are followed. Paths that do not exist are left
+// for the walk to report.
+func followLinks(client *sftp.Client, p string) (string, error) {
+	for range 40 {
+		fi, err := client.Lstat(p)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return p, nil
+		}
+		target, err := client.ReadLink(p)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) && !isWindowsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
+
+// isWindowsAbs reports whether p starts with a drive letter, as the
+// targets of links on Windows hosts do.
+func isWindowsAbs(p string) bool {
+	return len(p) >= 3 && p[1] == ':' && (p[2] == '/' || p[2] == '\\')
+}
+
+// fetchHostDir is the folder under dir that h's files go into.
+func fetchHostDir(dir string, h HostInfo) string {
+	return filepath.Join(dir, localName(h))
+}
+
+// fetchLocalPath mirrors remote under dir. Relative remote paths, which
+// start at the SSH user's home, are mirrored as they are, a Windows drive
+// letter becomes a directory, and nothing can climb out of dir.
+func fetchLocalPath(dir, remote string) string {
+	p := strings.ReplaceAll(path.Clean("/"+remote), ":", "")
+	return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(p, "/")))
+}
+
+// fetchSums fills in the SHA-256 of every item as the host sees it.
+func fetchSums(conn *ssh.Client, host string, items []fetchItem, windows bool) error {
+	if windows {
+		for i := range items {
+			if items[i].sum = remoteSHA256(conn, host, items[i].remote, true); items[i].sum == "" {
+				return fmt.Errorf("checksum %s: failed", items[i].remote)
+			}
+		}
+		return nil
+	}
+
+	var check strings.Builder
+	check.WriteString(remoteHash + "for f in")
+	for _, it := range items {
+		check.WriteString(" " + shellQuote(it.remote))
+	}
+	check.WriteString(`; do h < "$f" || echo -; done`)
+	res := runOn(conn, host, check.String())
+	if res.Error != nil {
+		return fmt.Errorf("checksum remote files: %w", res.Error)
+	}
+	sums := strings.Fields(res.Stdout)
+	if len(sums) != len(items) {
+		return fmt.Errorf("checksum remote files: got %d checksums for %d files", len(sums), len(items))
+	}
+	for i := range items {
+		items[i].sum = sums[i]
+	}
+	return nil
+}
+
+// fetchFile downloads it next to its local path, checks it and renames it
+// into place with the remote modification time.
+func fetchFile(conn *ssh.Client, client *sftp.Client, host string, it fetchItem, compress, windows bool) error {
+	if err := os.MkdirAll(filepath.Dir(it.local), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(it.local), "."+filepath.Base(it.local)+".godev-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	w := io.MultiWriter(tmp, h)
+	var n int64
+	if compress {
+		n, err = fetchCompressed(conn, it.remote, w)
+	} else {
+		n, err = fetchPlain(client, it.remote, w)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// A file still being written, a log most often, can have grown since
+	// it was checksummed; then what arrived must match its start.
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != it.sum {
+		want := ""
+		if !windows && n != it.size {
+			res := runOn(conn, host, remoteHash+"head -c "+strconv.FormatInt(n, 10)+" "+shellQuote(it.remote)+" | h")
+			if res.Error == nil {
+				want = strings.TrimSpace(res.Stdout)
+			}
+		}
+		if sum != want {
+			return fmt.Errorf("checksum mismatch after download")
+		}
+	}
+
+	if err := os.Chmod(tmp.Name(), it.perm|0o600); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), it.mtime, it.mtime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), it.local)
+}
+
+// fetchPlain copies remote to w over SFTP.
+func fetchPlain(client *sftp.Client, remote string, w io.Writer) (int64, error) {
+	f, err := client.Open(remote)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// fetchCompressed has the host gzip remote and unpacks the stream into w.
+func fetchCompressed(conn *ssh.Client, remote string, w io.Writer) (int64, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("new session: %v", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := session.Start("gzip -c < " + shellQuote(remote)); err != nil {
+		return 0, err
+	}
+
+	zr, err := gzip.NewReader(stdout)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(w, zr)
+	}
+	io.Copy(io.Discard, stdout)
+	if werr := session.Wait(); werr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return n, fmt.Errorf("gzip: %s", msg)
+		}
+		return n, fmt.Errorf("gzip: %v", werr)
+	}
+	if err != nil {
+		return n, fmt.Errorf("gzip: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchLocalPath(t *testing.T) {
+	dir := filepath.FromSlash("out/web1")
+	tests := []struct {
+		remote, want string
+	}{
+		{"/etc/hosts", "out/web1/etc/hosts"},
+		{"logs/app.log", "out/web1/logs/app.log"},
+		{"/C:/Users/me/a.txt", "out/web1/C/Users/me/a.txt"},
+		{"C:/Users/me/a.txt", "out/web1/C/Users/me/a.txt"},
+		// Nothing climbs out of dir.
+		{"../../etc/passwd", "out/web1/etc/passwd"},
+		{"/var/../../../root/x", "out/web1/root/x"},
+		{"/", "out/web1"},
+	}
+	for _, tt := range tests {
+		if got := fetchLocalPath(dir, tt.remote); got != filepath.FromSlash(tt.want) {
+			t.Errorf("fetchLocalPath(%q, %q) = %q, want %q", dir, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestFetchHostDir(t *testing.T) {
+	hosts := []HostInfo{
+		{User: "root", Host: "db1", Port: 22},
+		{User: "app", Host: "db1", Port: 2222},
+		{User: "me", Host: "web1", Port: 22},
+	}
+	NameHosts(hosts)
+	want := []string{"out/db1_22", "out/db1_2222", "out/web1"}
+	for i, h := range hosts {
+		if got := fetchHostDir("out", h); got != filepath.FromSlash(want[i]) {
+			t.Errorf("fetchHostDir for %s@%s:%d = %q, want %q", h.User, h.Host, h.Port, got, want[i])
+		}
+	}
+	// Without NameHosts the host name is used, made safe.
+	if got := fetchHostDir("out", HostInfo{Host: "fe80::1"}); got != filepath.FromSlash("out/fe80__1") {
+		t.Errorf("fetchHostDir without a LocalName = %q", got)
+	}
+}
+
+func TestIsWindowsAbs(t *testing.T) {
+	for p, want := range map[string]bool{`C:\x`: true, "C:/x": true, "/x": false, "x": false, "C:": false} {
+		if got := isWindowsAbs(p); got != want {
+			t.Errorf("isWindowsAbs(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// guards checks opts.Creates, opts.Unless and opts.OnlyIf on h over conn,
+// in that order, before anything is run there. Each guard command runs
+// the way the real run would, through h's become method with opts.Become.
+// When a guard says the run is not needed, the returned Result reports the
+// host as skipped and skip is true; when a guard could not be checked at
+// all, the Result carries the error and skip is true as well. Under
+// opts.Check only --creates, which changes nothing, is checked; the guard
+// commands could do anything, so checkReport.guards shows them instead.
+func guards(conn *ssh.Client, h HostInfo, opts Options, windows bool, start time.Time) (res Result, skip bool) {
+	if opts.Creates != "" {
+		cmd := "test -e " + shellQuote(opts.Creates)
+		if windows {
+			cmd = `cmd /C if exist ` + windowsQuote(opts.Creates) + ` (exit 0) else (exit 1)`
+		}
+		ok, err := guardPasses(conn, h, cmd, opts)
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("--creates: %w", err)), true
+		}
+		if ok {
+			return skippedResult(h.Host, start, opts, opts.Creates+" exists"), true
+		}
+	}
+	if opts.Unless != "" && !opts.Check {
+		ok, err := guardPasses(conn, h, opts.Unless, opts)
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("--unless: %w", err)), true
+		}
+		if ok {
+			return skippedResult(h.Host, start, opts, "--unless command succeeded"), true
+		}
+	}
+	if opts.OnlyIf != "" && !opts.Check {
+		ok, err := guardPasses(conn, h, opts.OnlyIf, opts)
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("--only-if: %w", err)), true
+		}
+		if !ok {
+			return skippedResult(h.Host, start, opts, "--only-if command failed"), true
+		}
+	}
+	return Result{}, false
+}
+
+// unprobedGuards is guards for callers that have not probed h's platform.
+// Only --creates needs it, so the host is only probed, once per run, when
+// that is set and the inventory has no os= for it.
+func unprobedGuards(conn *ssh.Client, h HostInfo, opts Options, start time.Time) (Result, bool) {
+	windows := h.OS == "windows"
+	if opts.Creates != "" && h.OS == "" {
+		p, err := probePlatform(conn, h)
+		if err != nil {
+			return failedResult(h.Host, start, fmt.Errorf("--creates: %w", err)), true
+		}
+		windows = p.OS == "windows"
+	}
+	return guards(conn, h, opts, windows, start)
+}
+
+// guardPasses runs cmd and reports whether it exited 0. Only a command
+// that could not be run, or privileges that could not be gained, is an
+// error; any other exit status is a plain no.
+func guardPasses(conn *ssh.Client, h HostInfo, cmd string, opts Options) (bool, error) {
+	opts.Stdout, opts.Stderr, opts.SkipCapture = nil, nil, false
+	res := execOn(conn, h, cmd, "", opts)
+	switch {
+	case res.Error == nil:
+		return true, nil
+	case errors.Is(res.Error, ErrBecomeAuth) || errors.Is(res.Error, ErrBecomePrompt) || res.ExitCode < 0:
+		return false, res.Error
+	}
+	return false, nil
+}
+
+// skippedResult is the Result for a host a guard turned away. The reason
+// is also written to opts.Stdout.
+func skippedResult(host string, start time.Time, opts Options, why string) Result {
+	line := Redact("skipped: " + why + "\n")
+	if opts.Stdout != nil {
+		io.WriteString(opts.Stdout, line)
+	}
+	return redactResult(Result{
+		Host:      host,
+		Stdout:    line,
+		Output:    line,
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Status:    "skipped",
+	})
+}
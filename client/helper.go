@@ -1,9 +1,14 @@
 package client
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
@@ -41,3 +46,96 @@ func isTimeoutError(err error) bool {
 	_, ok := err.(*ssh.ExitMissingError)
 	return ok
 }
+
+// exitStatus extracts the remote exit code and terminating signal from the
+// error returned by ssh.Session.Run or Wait.
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Signal() != "" {
+			return -1, exitErr.Signal()
+		}
+		return exitErr.ExitStatus(), ""
+	}
+	return -1, ""
+}
+
+// failedResult builds the Result for a host where nothing could be run.
+func failedResult(host string, start time.Time, err error) Result {
+	return redactResult(Result{
+		Host:      host,
+		Error:     err,
+		ExitCode:  -1,
+		StartedAt: start,
+		Duration:  time.Since(start),
+	})
+}
+
+// FailedResult is failedResult for callers outside the package, such as a
+// host whose script could not be built for it.
+func FailedResult(host string, start time.Time, err error) Result {
+	return failedResult(host, start, err)
+}
+
+// execSession runs cmd on session, feeding it stdin when non-nil, and
+// records its output, exit status and timing. Output is also copied to
+// opts.Stdout and opts.Stderr when they are set. The returned Result is
+// timed from start so callers can include the connection setup. Known
+// secrets are masked in everything it records or copies.
+func execSession(session *ssh.Session, host, cmd string, stdin io.Reader, opts Options, start time.Time) Result {
+	opts, flush := redactStreams(opts)
+	defer flush()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = captureWriter(&stdout, opts.Stdout, opts.SkipCapture)
+	session.Stderr = captureWriter(&stderr, opts.Stderr, opts.SkipCapture)
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+
+	err := session.Run(cmd)
+
+	res := Result{
+		Host:      host,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		StartedAt: start,
+		Duration:  time.Since(start),
+	}
+	res.Output = res.Stdout
+	res.ExitCode, res.Signal = exitStatus(err)
+	if err != nil {
+		res.Error = fmt.Errorf("ssh error: %w", err)
+	}
+	return redactResult(res)
+}
+
+// timedFrom stretches res's timing back to start, so the Result of the
+// final step covers the whole operation (uploads, chmod and so on).
+func timedFrom(res Result, start time.Time) Result {
+	res.StartedAt = start
+	res.Duration = time.Since(start)
+	return res
+}
+
+// captureWriter returns the writer a session's output goes to: buf, w, or
+// both, depending on whether w is set and capturing is skipped.
+func captureWriter(buf *bytes.Buffer, w io.Writer, skipCapture bool) io.Writer {
+	switch {
+	case w == nil && skipCapture:
+		return io.Discard
+	case w == nil:
+		return buf
+	case skipCapture:
+		return w
+	}
+	return io.MultiWriter(buf, w)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
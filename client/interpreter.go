@@ -0,0 +1,64 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unixInterpreters and windowsInterpreters map a script's extension to the
+// command that runs it, for scripts that are not run directly.
+var unixInterpreters = map[string]string{
+	".sh":   "sh",
+	".bash": "bash",
+	".py":   "python3",
+	".pl":   "perl",
+	".rb":   "ruby",
+	".ps1":  "pwsh -NoProfile -File",
+}
+
+var windowsInterpreters = map[string]string{
+	".bat": "cmd /C",
+	".cmd": "cmd /C",
+	".ps1": "powershell -NoProfile -ExecutionPolicy Bypass -File",
+	".py":  "python",
+	".pl":  "perl",
+	".rb":  "ruby",
+}
+
+// interpreterFor picks the command that runs scriptPath on h: the
+// inventory's interpreter= for the host, then opts.Interpreter, then one
+// chosen by extension. On Unix a script with a shebang line is run
+// directly unless an interpreter was asked for, so is anything with an
+// unknown extension, such as a binary. An empty result means the script
+// is run directly.
+func interpreterFor(h HostInfo, opts Options, scriptPath string, windows bool) string {
+	if h.Interpreter != "" {
+		return h.Interpreter
+	}
+	if opts.Interpreter != "" {
+		return opts.Interpreter
+	}
+
+	ext := strings.ToLower(filepath.Ext(scriptPath))
+	if windows {
+		return windowsInterpreters[ext]
+	}
+	if hasShebang(scriptPath) {
+		return ""
+	}
+	return unixInterpreters[ext]
+}
+
+// hasShebang reports whether the local file at path starts with "#!".
+func hasShebang(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 2)
+	n, _ := f.Read(head)
+	return bytes.Equal(head[:n], []byte("#!"))
+}
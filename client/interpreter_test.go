@@ -0,0 +1,52 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpreterFor(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := write("plain.sh", "echo hi\n")
+	shebang := write("shebang.sh", "#!/bin/bash\necho hi\n")
+	python := write("tool.py", "print('hi')\n")
+	ps1 := write("setup.ps1", "Write-Host hi\n")
+	bat := write("setup.BAT", "echo hi\n")
+	binary := write("agent", "\x7fELF")
+
+	tests := []struct {
+		name    string
+		h       HostInfo
+		opts    Options
+		script  string
+		windows bool
+		want    string
+	}{
+		{"sh by extension", HostInfo{}, Options{}, plain, false, "sh"},
+		{"shebang runs directly", HostInfo{}, Options{}, shebang, false, ""},
+		{"python on unix", HostInfo{}, Options{}, python, false, "python3"},
+		{"binary runs directly", HostInfo{}, Options{}, binary, false, ""},
+		{"ps1 on unix", HostInfo{}, Options{}, ps1, false, "pwsh -NoProfile -File"},
+		{"ps1 on windows", HostInfo{}, Options{}, ps1, true, "powershell -NoProfile -ExecutionPolicy Bypass -File"},
+		{"bat on windows, any case", HostInfo{}, Options{}, bat, true, "cmd /C"},
+		{"python on windows", HostInfo{}, Options{}, python, true, "python"},
+		{"exe on windows", HostInfo{}, Options{}, binary, true, ""},
+		{"--interpreter beats a shebang", HostInfo{}, Options{Interpreter: "bash -x"}, shebang, false, "bash -x"},
+		{"--interpreter on windows", HostInfo{}, Options{Interpreter: "pwsh -File"}, ps1, true, "pwsh -File"},
+		{"interpreter= beats --interpreter", HostInfo{Interpreter: "python3.12"}, Options{Interpreter: "python3"}, python, false, "python3.12"},
+		{"interpreter= on windows", HostInfo{Interpreter: "pwsh -File"}, Options{}, ps1, true, "pwsh -File"},
+	}
+	for _, tt := range tests {
+		if got := interpreterFor(tt.h, tt.opts, tt.script, tt.windows); got != tt.want {
+			t.Errorf("%s: interpreterFor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
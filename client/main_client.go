@@ -1,10 +1,10 @@
 package client
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 	"net"
@@ -16,17 +16,18 @@ import (
 // Updated callSSH with allowUnknownHosts
 func callSSH(command, user, password, host string, port int, timeout time.Duration, allowUnknownHosts bool, resultCh chan<- Result, wg *sync.WaitGroup) {
 	defer wg.Done()
+	start := time.Now()
 
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("get home directory: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("get home directory: %w", err))
 		return
 	}
 
 	khPath := filepath.Join(homeDir, ".ssh", "known_hosts")
 	kh, err := skeemakh.NewDB(khPath)
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("load known_hosts DB: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("load known_hosts DB: %w", err))
 		return
 	}
 
@@ -45,12 +46,12 @@ func callSSH(command, user, password, host string, port int, timeout time.Durati
 			}
 		}
 		if !keyTried {
-			resultCh <- Result{Host: host, Error: fmt.Errorf("no usable private key found in ~/.ssh")}
+			resultCh <- failedResult(host, start, fmt.Errorf("no usable private key found in ~/.ssh"))
 			return
 		}
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	config := &ssh.ClientConfig{
 		User:              user,
 		Auth:              authMethods,
@@ -63,13 +64,13 @@ func callSSH(command, user, password, host string, port int, timeout time.Durati
 	netDialer := net.Dialer{Timeout: timeout}
 	connRaw, err := netDialer.Dial("tcp", addr)
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("dial TCP: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("dial TCP: %w", err))
 		return
 	}
 
 	clientConn, chans, reqs, err := ssh.NewClientConn(connRaw, addr, config)
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("new client connection: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("new client connection: %w", err))
 		connRaw.Close()
 		return
 	}
@@ -77,32 +78,17 @@ func callSSH(command, user, password, host string, port int, timeout time.Durati
 
 
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("dial SSH: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("dial SSH: %w", err))
 		return
 	}
 	defer conn.Close()
 
 	session, err := conn.NewSession()
 	if err != nil {
-		resultCh <- Result{Host: host, Error: fmt.Errorf("new session: %w", err)}
+		resultCh <- failedResult(host, start, fmt.Errorf("new session: %w", err))
 		return
 	}
 	defer session.Close()
 
-	var stderrBuf bytes.Buffer
-	session.Stderr = &stderrBuf
-
-	output, err := session.Output(command)
-	if err != nil {
-		stderrOutput := stderrBuf.String()
-		resultCh <- Result{Host: host, Error: fmt.Errorf("command error: %v; stderr: %s", err, stderrOutput)}
-		return
-	}
-
-	combinedOutput := string(output)
-	if stderrBuf.Len() > 0 {
-		combinedOutput += "\n" + stderrBuf.String()
-	}
-
-	resultCh <- Result{Host: host, Output: combinedOutput}
+	resultCh <- execSession(session, host, command, nil, Options{}, start)
 }
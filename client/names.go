@@ -0,0 +1,73 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+)
+
+// reservedNames are names a host's local files cannot have, because
+// godev keeps files of its own under them next to the hosts'.
+var reservedNames = map[string]bool{"manifest": true}
+
+// NameHosts sets every host's LocalName. A host is named after its host
+// name, unless another entry in hosts would get the same name; then it is
+// host_port, and user@host_port if that still is not enough. Entries
+// listed twice get "-2", "-3" and so on. Characters file systems do not
+// allow in names become '_'.
+func NameHosts(hosts []HostInfo) {
+	forms := []func(h HostInfo) string{
+		func(h HostInfo) string { return h.Host },
+		func(h HostInfo) string { return h.Host + "_" + strconv.Itoa(h.Port) },
+		func(h HostInfo) string { return h.User + "@" + h.Host + "_" + strconv.Itoa(h.Port) },
+	}
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = safeName(forms[0](h))
+	}
+	for _, form := range forms[1:] {
+		count := map[string]int{}
+		for _, n := range names {
+			count[n]++
+		}
+		for i, h := range hosts {
+			if count[names[i]] > 1 || reservedNames[names[i]] {
+				names[i] = safeName(form(h))
+			}
+		}
+	}
+
+	taken := map[string]bool{}
+	for i, n := range names {
+		name := n
+		for k := 2; taken[name]; k++ {
+			name = n + "-" + strconv.Itoa(k)
+		}
+		taken[name] = true
+		hosts[i].LocalName = name
+	}
+}
+
+// localName is h's LocalName, or its host name made safe when NameHosts
+// was not used.
+func localName(h HostInfo) string {
+	if h.LocalName != "" {
+		return h.LocalName
+	}
+	return safeName(h.Host)
+}
+
+// safeName replaces what is not allowed in file names on Unix or Windows,
+// and keeps the name from being "." or ".." or hidden.
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, name)
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,40 @@
+package client
+
+import "testing"
+
+func TestNameHosts(t *testing.T) {
+	tests := []struct {
+		hosts []HostInfo
+		want  []string
+	}{
+		{
+			[]HostInfo{{User: "me", Host: "web1", Port: 22}, {User: "me", Host: "web2", Port: 22}},
+			[]string{"web1", "web2"},
+		},
+		// Only the entries that collide are told apart.
+		{
+			[]HostInfo{{User: "root", Host: "db1", Port: 22}, {User: "app", Host: "db1", Port: 2222}, {User: "me", Host: "web1", Port: 22}},
+			[]string{"db1_22", "db1_2222", "web1"},
+		},
+		{
+			[]HostInfo{{User: "root", Host: "db1", Port: 22}, {User: "app", Host: "db1", Port: 22}},
+			[]string{"root@db1_22", "app@db1_22"},
+		},
+		{
+			[]HostInfo{{User: "me", Host: "db1", Port: 22}, {User: "me", Host: "db1", Port: 22}},
+			[]string{"me@db1_22", "me@db1_22-2"},
+		},
+		{
+			[]HostInfo{{User: "me", Host: "fe80::1", Port: 22}, {User: "me", Host: "manifest", Port: 22}},
+			[]string{"fe80__1", "manifest_22"},
+		},
+	}
+	for _, tt := range tests {
+		NameHosts(tt.hosts)
+		for i, h := range tt.hosts {
+			if h.LocalName != tt.want[i] {
+				t.Errorf("NameHosts gave %s:%d the name %q, want %q", h.Host, h.Port, h.LocalName, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Platform is a remote host's operating system and CPU architecture, named
+// the way GOOS and GOARCH name them.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+var unameOS = map[string]string{
+	"linux":     "linux",
+	"darwin":    "darwin",
+	"freebsd":   "freebsd",
+	"openbsd":   "openbsd",
+	"netbsd":    "netbsd",
+	"dragonfly": "dragonfly",
+	"sunos":     "solaris",
+	"aix":       "aix",
+}
+
+var unameArch = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"i86pc":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"armv6l":  "arm",
+	"armv7l":  "arm",
+	"ppc64le": "ppc64le",
+	"ppc64":   "ppc64",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+	"mips64":  "mips64",
+}
+
+var windowsArch = map[string]string{
+	"amd64": "amd64",
+	"arm64": "arm64",
+	"x86":   "386",
+}
+
+// knownOS lists the names an inventory os= attribute may use.
+var knownOS = []string{"aix", "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd", "solaris", "windows"}
+
+// ValidOS reports an error for an os= value godev does not know.
+func ValidOS(name string) error {
+	for _, known := range knownOS {
+		if known == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown os %q (want one of %v)", name, knownOS)
+}
+
+// platforms caches what each host turned out to be, keyed by host:port,
+// so it is probed once however many times a run connects to it.
+var platforms struct {
+	mu    sync.Mutex
+	cache map[string]Platform
+}
+
+// ProbePlatform reports what h runs. See probePlatform.
+func ProbePlatform(h HostInfo, opts Options) (Platform, error) {
+	if p, ok := cachedPlatform(h); ok {
+		return p, nil
+	}
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return Platform{}, err
+	}
+	defer conn.Close()
+	return probePlatform(conn, h)
+}
+
+func cachedPlatform(h HostInfo) (Platform, bool) {
+	platforms.mu.Lock()
+	defer platforms.mu.Unlock()
+	p, ok := platforms.cache[net.JoinHostPort(h.Host, strconv.Itoa(h.Port))]
+	return p, ok
+}
+
+// probePlatform asks h over conn what it runs: uname -sm on Unix, and ver
+// plus %PROCESSOR_ARCHITECTURE% through cmd.exe on Windows, which is only
+// tried once uname has run and failed. h.OS, from the inventory, skips
+// the guessing and always wins over what the host says. The answer is
+// cached per host.
+func probePlatform(conn *ssh.Client, h HostInfo) (Platform, error) {
+	if p, ok := cachedPlatform(h); ok {
+		return p, nil
+	}
+
+	p, err := detectPlatform(conn, h)
+	if err != nil {
+		return Platform{}, fmt.Errorf("probe platform: %w", err)
+	}
+	if h.OS != "" {
+		p.OS = h.OS
+	}
+
+	platforms.mu.Lock()
+	defer platforms.mu.Unlock()
+	if platforms.cache == nil {
+		platforms.cache = map[string]Platform{}
+	}
+	platforms.cache[net.JoinHostPort(h.Host, strconv.Itoa(h.Port))] = p
+	return p, nil
+}
+
+func detectPlatform(conn *ssh.Client, h HostInfo) (Platform, error) {
+	if h.OS != "windows" {
+		res := runOn(conn, h.Host, "uname -sm")
+		if res.Error == nil {
+			return parseUname(res.Stdout)
+		}
+		// No exit status means nothing ran at all, and a host the
+		// inventory says is Unix is not worth asking cmd.exe.
+		if res.ExitCode < 0 || h.OS != "" {
+			return Platform{}, res.Error
+		}
+	}
+
+	res := runOn(conn, h.Host, `cmd /C "ver & echo %PROCESSOR_ARCHITECTURE%"`)
+	if res.Error != nil {
+		return Platform{}, fmt.Errorf("neither uname nor ver ran: %w", res.Error)
+	}
+	return parseVer(res.Stdout, h.OS == "")
+}
+
+// parseUname reads the output of uname -sm.
+func parseUname(out string) (Platform, error) {
+	fields := strings.Fields(strings.ToLower(out))
+	if len(fields) != 2 {
+		return Platform{}, fmt.Errorf("unexpected uname output %q", strings.TrimSpace(out))
+	}
+	return Platform{OS: lookup(unameOS, fields[0]), Arch: lookup(unameArch, fields[1])}, nil
+}
+
+// parseVer reads the output of ver followed by %PROCESSOR_ARCHITECTURE%.
+// Unless the inventory already said the host runs Windows, ver has to say
+// so too.
+func parseVer(out string, mustSayWindows bool) (Platform, error) {
+	out = strings.TrimSpace(out)
+	if mustSayWindows && !strings.Contains(out, "Windows") {
+		return Platform{}, fmt.Errorf("unrecognised ver output %q", out)
+	}
+	lines := strings.Split(out, "\n")
+	arch := strings.ToLower(strings.TrimSpace(lines[len(lines)-1]))
+	return Platform{OS: "windows", Arch: lookup(windowsArch, arch)}, nil
+}
+
+// lookup maps a name the host printed to its Go name, passing through
+// names it does not know so errors downstream can show them.
+func lookup(names map[string]string, name string) string {
+	if goName, ok := names[name]; ok {
+		return goName
+	}
+	return name
+}
@@ -0,0 +1,54 @@
+package client
+
+import "testing"
+
+func TestParseUname(t *testing.T) {
+	tests := []struct {
+		out  string
+		want Platform
+	}{
+		{"Linux x86_64\n", Platform{OS: "linux", Arch: "amd64"}},
+		{"Linux aarch64\n", Platform{OS: "linux", Arch: "arm64"}},
+		{"Linux armv7l\n", Platform{OS: "linux", Arch: "arm"}},
+		{"Darwin arm64\n", Platform{OS: "darwin", Arch: "arm64"}},
+		{"FreeBSD amd64\n", Platform{OS: "freebsd", Arch: "amd64"}},
+		{"SunOS i86pc\n", Platform{OS: "solaris", Arch: "amd64"}},
+		// Names godev does not know are passed on for errors to show.
+		{"Plan9 vax\n", Platform{OS: "plan9", Arch: "vax"}},
+	}
+	for _, tt := range tests {
+		got, err := parseUname(tt.out)
+		if err != nil || got != tt.want {
+			t.Errorf("parseUname(%q) = %v, %v; want %v", tt.out, got, err, tt.want)
+		}
+	}
+	for _, out := range []string{"", "Linux\n", "Linux host1 6.1.0 x86_64\n"} {
+		if got, err := parseUname(out); err == nil {
+			t.Errorf("parseUname(%q) = %v, want an error", out, got)
+		}
+	}
+}
+
+func TestParseVer(t *testing.T) {
+	tests := []struct {
+		out            string
+		mustSayWindows bool
+		want           Platform
+	}{
+		{"\r\nMicrosoft Windows [Version 10.0.20348.2227]\r\nAMD64\r\n", true, Platform{OS: "windows", Arch: "amd64"}},
+		{"\r\nMicrosoft Windows [Version 10.0.22631.3007]\r\nARM64\r\n", true, Platform{OS: "windows", Arch: "arm64"}},
+		{"Microsoft Windows [Version 6.1.7601]\r\nx86\r\n", true, Platform{OS: "windows", Arch: "386"}},
+		// A localized ver is fine when the inventory said os=windows.
+		{"Microsoft Windows [Versión 10.0.19045]\r\nAMD64\r\n", false, Platform{OS: "windows", Arch: "amd64"}},
+		{"Sistema [10.0]\r\nAMD64\r\n", false, Platform{OS: "windows", Arch: "amd64"}},
+	}
+	for _, tt := range tests {
+		got, err := parseVer(tt.out, tt.mustSayWindows)
+		if err != nil || got != tt.want {
+			t.Errorf("parseVer(%q, %v) = %v, %v; want %v", tt.out, tt.mustSayWindows, got, err, tt.want)
+		}
+	}
+	if got, err := parseVer("sh: cmd: not found\n", true); err == nil {
+		t.Errorf("parseVer of a shell error = %v, want an error", got)
+	}
+}
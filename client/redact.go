@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretMask replaces every known secret in output, errors and reports.
+const secretMask = "********"
+
+// minSecretLen keeps trivially short values from masking half the output.
+const minSecretLen = 3
+
+// maxPendingLine bounds how much of an unterminated line a redactWriter
+// holds back before writing it out anyway.
+const maxPendingLine = 64 * 1024
+
+var secrets struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	longest  int
+	replacer *strings.Replacer
+}
+
+// AddSecret registers a value that must never be printed or written. Every
+// Result the client package returns, and everything it streams to
+// Options.Stdout and Options.Stderr, has it masked.
+func AddSecret(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLen {
+		return
+	}
+
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if secrets.values == nil {
+		secrets.values = map[string]bool{}
+	}
+	if secrets.values[value] {
+		return
+	}
+	secrets.values[value] = true
+
+	// Longer secrets go first so one that contains another is masked
+	// whole.
+	list := make([]string, 0, len(secrets.values))
+	for v := range secrets.values {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, 2*len(list))
+	for _, v := range list {
+		pairs = append(pairs, v, secretMask)
+	}
+	secrets.replacer = strings.NewReplacer(pairs...)
+	secrets.longest = len(list[0])
+}
+
+// Redact masks every registered secret in s.
+func Redact(s string) string {
+	secrets.mu.RLock()
+	r := secrets.replacer
+	secrets.mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// redactedError masks secrets in an error's message while keeping the
+// original available to errors.Is and errors.As.
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string { return Redact(e.err.Error()) }
+func (e redactedError) Unwrap() error { return e.err }
+
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(redactedError); ok {
+		return err
+	}
+	return redactedError{err}
+}
+
+// redactResult masks secrets in everything res carries.
+func redactResult(res Result) Result {
+	res.Stdout = Redact(res.Stdout)
+	res.Stderr = Redact(res.Stderr)
+	res.Output = Redact(res.Output)
+	res.Error = redactError(res.Error)
+	if res.Steps != nil {
+		steps := make([]StepResult, len(res.Steps))
+		for i, st := range res.Steps {
+			st.Command = Redact(st.Command)
+			st.Stdout = Redact(st.Stdout)
+			st.Stderr = Redact(st.Stderr)
+			st.Error = redactError(st.Error)
+			steps[i] = st
+		}
+		res.Steps = steps
+	}
+	return res
+}
+
+// redactWriter masks secrets in a stream before passing it on. It works a
+// line at a time so a secret split across two writes is still caught;
+// Flush writes out whatever is left once the stream ends.
+type redactWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// redactStreams puts a redactWriter in front of opts.Stdout and
+// opts.Stderr. The returned flush must be called once the session is done.
+func redactStreams(opts Options) (Options, func()) {
+	var writers []*redactWriter
+	wrap := func(w io.Writer) io.Writer {
+		if w == nil {
+			return nil
+		}
+		rw := &redactWriter{w: w}
+		writers = append(writers, rw)
+		return rw
+	}
+	opts.Stdout = wrap(opts.Stdout)
+	opts.Stderr = wrap(opts.Stderr)
+	return opts, func() {
+		for _, rw := range writers {
+			rw.Flush()
+		}
+	}
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+
+	cut := bytes.LastIndexByte(r.buf, '\n') + 1
+	if cut == 0 && len(r.buf) > maxPendingLine {
+		cut = r.safeCut()
+	}
+	if cut > 0 {
+		if _, err := io.WriteString(r.w, Redact(string(r.buf[:cut]))); err != nil {
+			return 0, err
+		}
+		r.buf = append(r.buf[:0], r.buf[cut:]...)
+	}
+	return len(p), nil
+}
+
+// safeCut picks where to split an overlong line: far enough from the end
+// that a secret starting before the split could still be completed, and
+// never inside a secret that is already there.
+func (r *redactWriter) safeCut() int {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+
+	cut := len(r.buf) - secrets.longest
+	for v := range secrets.values {
+		from := cut - len(v) + 1
+		if from < 0 {
+			from = 0
+		}
+		if i := bytes.Index(r.buf[from:], []byte(v)); i >= 0 && from+i < cut {
+			cut = from + i
+		}
+	}
+	return cut
+}
+
+// Flush writes out a trailing partial line.
+func (r *redactWriter) Flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(r.w, Redact(string(r.buf)))
+	r.buf = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func init() {
+	AddSecret("hunter2")
+	AddSecret("hunter2-long")
+	AddSecret("abcdef")
+	AddSecret("cdefgh")
+	AddSecret("no") // too short to be masked
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"nothing here", "nothing here"},
+		{"pw=hunter2.", "pw=********."},
+		{"hunter2 and hunter2", "******** and ********"},
+		// The longer secret is masked whole, not as hunter2 plus a tail.
+		{"hunter2-long", "********"},
+		{"no secret", "no secret"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactOverlapping(t *testing.T) {
+	got := Redact("xabcdefghx")
+	for _, s := range []string{"abcdef", "cdefgh"} {
+		if strings.Contains(got, s) {
+			t.Errorf("Redact of overlapping secrets = %q, still shows %q", got, s)
+		}
+	}
+}
+
+func TestRedactWriterStraddlingWrites(t *testing.T) {
+	tests := [][]string{
+		{"pw: hun", "ter2\n"},
+		{"pw: h", "u", "n", "t", "e", "r", "2", "\n"},
+		{"pw: hunter", "2\nnext ", "hunter2\n"},
+		// A trailing partial line only comes out on Flush.
+		{"pw: hunt", "er2"},
+	}
+	for _, writes := range tests {
+		var out bytes.Buffer
+		w := &redactWriter{w: &out}
+		for _, p := range writes {
+			w.Write([]byte(p))
+		}
+		w.Flush()
+		want := Redact(strings.Join(writes, ""))
+		if out.String() != want {
+			t.Errorf("writes %q gave %q, want %q", writes, out.String(), want)
+		}
+	}
+}
+
+func TestRedactWriterHoldsBackLines(t *testing.T) {
+	var out bytes.Buffer
+	w := &redactWriter{w: &out}
+	w.Write([]byte("done\npartial hun"))
+	if out.String() != "done\n" {
+		t.Errorf("wrote %q before the line was complete, want %q", out.String(), "done\n")
+	}
+}
+
+func TestRedactWriterLongLine(t *testing.T) {
+	// A line longer than maxPendingLine is written out in pieces; a secret
+	// across the point where it is split must still be masked.
+	for _, at := range []int{maxPendingLine - 3, maxPendingLine - 7, maxPendingLine, maxPendingLine + 2} {
+		line := strings.Repeat("x", at) + "hunter2" + strings.Repeat("y", 100)
+		var out bytes.Buffer
+		w := &redactWriter{w: &out}
+		for i := 0; i < len(line); i += 1000 {
+			end := min(i+1000, len(line))
+			w.Write([]byte(line[i:end]))
+		}
+		w.Flush()
+		if got := out.String(); got != Redact(line) {
+			t.Errorf("secret at %d: got %d bytes with %d masks, want %d bytes with 1",
+				at, len(got), strings.Count(got, secretMask), len(Redact(line)))
+		}
+	}
+}
+
+func TestSafeCut(t *testing.T) {
+	tests := []struct {
+		buf  string
+		want int
+	}{
+		// Far enough back that a secret starting before the cut is whole.
+		{strings.Repeat("x", 40), 40 - len("hunter2-long")},
+		// Never inside a secret that is already there.
+		{strings.Repeat("x", 30) + "hunter2" + strings.Repeat("x", 10), 30},
+		{strings.Repeat("x", 30) + "abcdef" + strings.Repeat("x", 8), 30},
+		// A secret wholly before the cut is left for Redact.
+		{"hunter2" + strings.Repeat("x", 30), 37 - len("hunter2-long")},
+	}
+	for _, tt := range tests {
+		w := &redactWriter{buf: []byte(tt.buf)}
+		if got := w.safeCut(); got != tt.want {
+			t.Errorf("safeCut(%q) = %d, want %d", tt.buf, got, tt.want)
+		}
+	}
+}
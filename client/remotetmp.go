@@ -0,0 +1,181 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteTmp is a private directory on a remote host that a script is
+// uploaded into for a single run, so concurrent runs never share a path and
+// other users on the host cannot read or swap the file before it runs.
+type remoteTmp struct {
+	dir     string
+	windows bool
+}
+
+// makeRemoteTmp creates a remoteTmp on h over conn: with mktemp -d, which
+// creates the directory 0700, on Unix, and as a uniquely named directory
+// under %TEMP% (a per-user profile directory) on Windows.
+func makeRemoteTmp(conn *ssh.Client, h HostInfo, opts Options, windows bool) (remoteTmp, error) {
+	if !windows {
+		base := `"${TMPDIR:-/tmp}"`
+		if opts.RemoteTmp != "" {
+			base = shellQuote(opts.RemoteTmp)
+		}
+		res := runOn(conn, h.Host, "umask 077 && mktemp -d "+base+"/godev.XXXXXXXXXX")
+		if res.Error != nil {
+			return remoteTmp{}, fmt.Errorf("create remote temp dir: %w", res.Error)
+		}
+		dir := strings.TrimSpace(res.Stdout)
+		if !strings.HasPrefix(dir, "/") {
+			return remoteTmp{}, fmt.Errorf("create remote temp dir: mktemp printed %q", dir)
+		}
+		return remoteTmp{dir: dir}, nil
+	}
+
+	base := "$env:TEMP"
+	if opts.RemoteTmp != "" {
+		base = "'" + strings.ReplaceAll(opts.RemoteTmp, "'", "''") + "'"
+	}
+	res := runOn(conn, h.Host,
+		`powershell -NoProfile -NonInteractive -Command "$d = Join-Path `+base+
+			` ('godev-' + [guid]::NewGuid().ToString('N')); New-Item -ItemType Directory -Path $d | Out-Null; $d"`)
+	if res.Error != nil {
+		return remoteTmp{}, fmt.Errorf("create remote temp dir: %w", res.Error)
+	}
+	dir := strings.TrimSpace(res.Stdout)
+	if dir == "" {
+		return remoteTmp{}, fmt.Errorf("create remote temp dir: powershell printed nothing")
+	}
+	return remoteTmp{dir: dir, windows: true}, nil
+}
+
+// path returns where a file called name goes inside t.
+func (t remoteTmp) path(name string) string {
+	if t.windows {
+		return t.dir + `\` + name
+	}
+	return path.Join(t.dir, name)
+}
+
+// command returns the command line that runs the file at remote through
+// interpreter (directly when empty), with opts.Args as its arguments and
+// opts.Env in its environment. Every value is quoted, so nothing in them
+// is expanded by the remote shell. On Windows the whole line goes through
+// cmd.exe, whichever shell the SSH server starts.
+func (t remoteTmp) command(remote, interpreter string, opts Options) string {
+	var b strings.Builder
+	if interpreter != "" {
+		interpreter += " "
+	}
+	if t.windows {
+		for _, kv := range opts.Env {
+			b.WriteString("set " + cmdEscape(kv) + "&& ")
+		}
+		b.WriteString(interpreter + `"` + remote + `"`)
+		for _, a := range opts.Args {
+			b.WriteString(" " + windowsQuote(a))
+		}
+		return `cmd /C "` + b.String() + `"`
+	}
+	for _, kv := range opts.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		b.WriteString(k + "=" + shellQuote(v) + " ")
+	}
+	b.WriteString(interpreter + shellQuote(remote))
+	for _, a := range opts.Args {
+		b.WriteString(" " + shellQuote(a))
+	}
+	return b.String()
+}
+
+// cmdEscape escapes s for cmd.exe outside quotes by putting a caret
+// before every character cmd treats specially. Quotes cannot be used
+// instead, because cmd expands %NAME% even between them. Behind a caret a
+// % only reaches as far as a variable name ending in ^, which never
+// exists, so it is left alone and loses the caret afterwards.
+func cmdEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("^&|<>()%!\"", r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// windowsQuote quotes s as one argument the way the Microsoft C runtime
+// splits command lines.
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"&|<>^") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes before a quote are doubled, plus one for the
+			// quote itself.
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteRune(r)
+	}
+	// So are those before the closing quote.
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// grant lets user, who a script is about to be run as, into t. The
+// directory stays closed to everyone else; hosts without setfacl get an
+// error rather than a world-readable script.
+func (t remoteTmp) grant(conn *ssh.Client, h HostInfo, user, remote string) error {
+	if t.windows || user == "" || user == "root" || user == h.User {
+		return nil
+	}
+	acl := "u:" + user + ":rx"
+	res := runOn(conn, h.Host, "setfacl -m "+shellQuote(acl)+" "+shellQuote(t.dir)+" "+shellQuote(remote))
+	if res.Error != nil {
+		return fmt.Errorf("give %s access to %s: %w %s", user, t.dir, res.Error, strings.TrimSpace(res.Stderr))
+	}
+	return nil
+}
+
+// cleanup deletes t and everything in it, unless opts.KeepRemote is set.
+// Neither a kept directory nor one that could not be deleted fails the
+// run, so either is only noted at the end of res's stderr, and on
+// opts.Stderr when output is streamed.
+func (t remoteTmp) cleanup(conn *ssh.Client, h HostInfo, opts Options, res *Result) {
+	var note string
+	if opts.KeepRemote {
+		note = fmt.Sprintf("[INFO] kept remote temp dir %s\n", t.dir)
+	} else {
+		cmd := "rm -rf " + shellQuote(t.dir)
+		if t.windows {
+			cmd = `powershell -NoProfile -NonInteractive -Command "Remove-Item -LiteralPath '` +
+				strings.ReplaceAll(t.dir, "'", "''") + `' -Recurse -Force"`
+		}
+		rm := runOn(conn, h.Host, cmd)
+		if rm.Error == nil {
+			return
+		}
+		note = fmt.Sprintf("[WARN] could not remove %s: %v\n", t.dir, rm.Error)
+	}
+	if opts.Stderr != nil {
+		fmt.Fprint(opts.Stderr, note)
+	}
+	if !opts.SkipCapture {
+		res.Stderr += note
+	}
+}
@@ -0,0 +1,28 @@
+package client
+
+import "testing"
+
+func TestCmdEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"K=plain", "K=plain"},
+		{"K=50%PATH%", "K=50^%PATH^%"},
+		{`K=a&b|c<d>e(f)g^h!i"j`, `K=a^&b^|c^<d^>e^(f^)g^^h^!i^"j`},
+		{"K=two words", "K=two words"},
+	}
+	for _, tt := range tests {
+		if got := cmdEscape(tt.in); got != tt.want {
+			t.Errorf("cmdEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWindowsCommandEnv(t *testing.T) {
+	tmp := remoteTmp{dir: `C:\t`, windows: true}
+	got := tmp.command(`C:\t\x.bat`, "", Options{Env: []string{"A=1%", "B=x y"}})
+	want := `cmd /C "set A=1^%&& set B=x y&& "C:\t\x.bat""`
+	if got != want {
+		t.Errorf("command = %s, want %s", got, want)
+	}
+}
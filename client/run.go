@@ -2,25 +2,28 @@ package client
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 	"net"
 	"context"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	skeemakh "github.com/skeema/knownhosts"
 )
 
-// Updated Run signature to include allowUnknownHosts
-func Run(user, password, filePath, host string, port int, timeout time.Duration, allowUnknownHosts bool) (string, error) {
+// Run executes every command in filePath on h as a single script.
+func Run(h HostInfo, filePath string, opts Options) Result {
+	start := time.Now()
+
 	// Read all commands from file into a single big script
 	var script string
 	file, err := os.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("open file: %w", err)
+		return failedResult(h.Host, start, fmt.Errorf("open file: %w", err))
 	}
 	defer file.Close()
 
@@ -32,57 +35,99 @@ func Run(user, password, filePath, host string, port int, timeout time.Duration,
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("scanner error: %w", err)
+		return failedResult(h.Host, start, fmt.Errorf("scanner error: %w", err))
 	}
 
-	// Pass allowUnknownHosts to connectSSH
-	conn, session, err := connectSSH(user, password, host, port, timeout, allowUnknownHosts)
+	return timedFrom(RunScript(h, script, opts), start)
+}
+
+// RunScript executes script on h in a single session. With opts.Become it
+// runs with privileges gained through h's become method, and h's sudo
+// password, if any, is never put on the remote command line.
+func RunScript(h HostInfo, script string, opts Options) Result {
+	start := time.Now()
+
+	conn, session, err := connectSSH(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
 	if err != nil {
-		return "", err
+		return failedResult(h.Host, start, err)
 	}
 	defer conn.Close()
 	defer session.Close()
 
-	var outputBuf, stderrBuf bytes.Buffer
-	session.Stdout = &outputBuf
-	session.Stderr = &stderrBuf
+	if res, skip := unprobedGuards(conn, h, opts, start); skip {
+		return res
+	}
+	if opts.Check {
+		return checkScript(conn, h, []string{script}, opts, start)
+	}
+	if opts.Become {
+		return execBecome(session, h, script, opts, start)
+	}
 
 	// Run the big script
-	err = session.Run(script)
+	return execSession(session, h.Host, script, nil, opts, start)
+}
+
+func connectSSH(user, password, host string, port int, timeout time.Duration, allowUnknownHosts bool) (*ssh.Client, *ssh.Session, error) {
+	client, err := connectClient(user, password, host, port, timeout, allowUnknownHosts)
 	if err != nil {
-		return "", fmt.Errorf("ssh command error: %v\nstderr: %s", err, stderrBuf.String())
+		return nil, nil, err
 	}
 
-	return outputBuf.String(), nil
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("new SSH session: %w", err)
+	}
+
+	return client, session, nil
 }
 
-func connectSSH(user, password, host string, port int, timeout time.Duration, allowUnknownHosts bool) (*ssh.Client, *ssh.Session, error) {
+// connectClient opens an SSH connection checked against known_hosts, for
+// callers that need more than one session on it.
+func connectClient(user, password, host string, port int, timeout time.Duration, allowUnknownHosts bool) (*ssh.Client, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, nil, fmt.Errorf("get home directory: %w", err)
+		return nil, fmt.Errorf("get home directory: %w", err)
 	}
 
 	khPath := filepath.Join(homeDir, ".ssh", "known_hosts")
 	kh, err := skeemakh.NewDB(khPath)
 	if err != nil {
-		return nil, nil, fmt.Errorf("load known_hosts DB: %w", err)
+		return nil, fmt.Errorf("load known_hosts DB: %w", err)
 	}
 
 	var authMethods []ssh.AuthMethod
 	if password != "" {
 		authMethods = append(authMethods, ssh.Password(password))
 	} else {
+		// Keys from a running SSH agent come first, then the first key
+		// file found. They have to be one method, since the SSH client
+		// only tries publickey once. The agent is only needed until the
+		// handshake is done.
+		var signers []ssh.Signer
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if c, err := net.Dial("unix", sock); err == nil {
+				defer c.Close()
+				if agentSigners, err := agent.NewClient(c).Signers(); err == nil {
+					signers = append(signers, agentSigners...)
+				}
+			}
+		}
 		for _, filename := range []string{"id_rsa", "id_ed25519"} {
 			keyPath := filepath.Join(homeDir, ".ssh", filename)
 			key, err := privateKeyFile(keyPath)
 			if err == nil {
-				authMethods = append(authMethods, ssh.PublicKeys(key))
+				signers = append(signers, key)
 				break
 			}
 		}
+		if len(signers) > 0 {
+			authMethods = append(authMethods, ssh.PublicKeys(signers...))
+		}
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	config := &ssh.ClientConfig{
 		User:              user,
 		Auth:              authMethods,
@@ -94,23 +139,15 @@ func connectSSH(user, password, host string, port int, timeout time.Duration, al
 	dialer := net.Dialer{Timeout: timeout}
 	conn, err := dialer.DialContext(context.Background(), "tcp", addr)
 	if err != nil {
-		return nil, nil, fmt.Errorf("net dial: %w", err)
+		return nil, fmt.Errorf("net dial: %w", err)
 	}
 
 	// Establish the SSH connection on top of the TCP connection
 	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
 		conn.Close()
-		return nil, nil, fmt.Errorf("ssh client conn: %w", err)
-	}
-
-	client := ssh.NewClient(sshConn, chans, reqs)
-
-	session, err := client.NewSession()
-	if err != nil {
-		client.Close()
-		return nil, nil, fmt.Errorf("new SSH session: %w", err)
+		return nil, fmt.Errorf("ssh client conn: %w", err)
 	}
 
-	return client, session, nil
+	return ssh.NewClient(sshConn, chans, reqs), nil
 }
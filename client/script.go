@@ -1,278 +1,116 @@
 package client
 
 import (
-    "bytes"
     "fmt"
-    "io"
-    "net"
-    "os"
-    "os/exec"
     "path/filepath"
     "strings"
     "time"
 
-    "github.com/pkg/sftp"
     "golang.org/x/crypto/ssh"
-    "golang.org/x/crypto/ssh/agent"
 )
 
-// dialSSH establishes an SSH connection (client only).
-func dialSSH(user, password, host string, port int, timeout time.Duration) (*ssh.Client, error) {
-    var methods []ssh.AuthMethod
-
-    if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
-        if c, err := net.Dial("unix", sock); err == nil {
-            methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
-        }
-    }
-    if strings.TrimSpace(password) != "" {
-        methods = append(methods, ssh.Password(password))
-    }
-    if len(methods) == 0 {
-        return nil, fmt.Errorf("no authentication methods available")
-    }
-
-    cfg := &ssh.ClientConfig{
-        User:            user,
-        Auth:            methods,
-        HostKeyCallback: ssh.InsecureIgnoreHostKey(), 
-    }
-
-    addr := fmt.Sprintf("%s:%d", host, port)
-    netDialer := net.Dialer{Timeout: timeout}
-    conn, err := netDialer.Dial("tcp", addr)
-    if err != nil {
-        return nil, fmt.Errorf("tcp dial error: %w", err)
-    }
-
-    c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
-    if err != nil {
-        conn.Close()
-        return nil, fmt.Errorf("ssh client conn: %w", err)
-    }
-
-    return ssh.NewClient(c, chans, reqs), nil
+// RunRemoteScript uploads and runs a Unix-style script (.sh, no extension, etc).
+func RunRemoteScript(h HostInfo, scriptPath string, opts Options) Result {
+    opts.Become = false
+    return runUploaded(h, scriptPath, opts)
 }
 
-// runSSH runs a command over SSH and returns its stdout.
-func runSSH(user, password, host string, port int, timeout time.Duration, cmd string) (string, error) {
-    conn, err := dialSSH(user, password, host, port, timeout)
-    if err != nil {
-        return "", err
+// RunRemoteScriptWithSudo uploads and runs a script, with privileges when h
+// has a sudo password or opts.Become is set.
+func RunRemoteScriptWithSudo(h HostInfo, scriptPath string, opts Options) Result {
+    if strings.TrimSpace(h.SudoPassword) != "" {
+        opts.Become = true
     }
-    defer conn.Close()
-
-    session, err := conn.NewSession()
-    if err != nil {
-        return "", fmt.Errorf("new session: %v", err)
-    }
-    defer session.Close()
-
-    var out, errbuf bytes.Buffer
-    session.Stdout = &out
-    session.Stderr = &errbuf
-
-    if err := session.Run(cmd); err != nil {
-        return "", fmt.Errorf("ssh error: %v, stderr: %s", err, errbuf.String())
-    }
-    return out.String(), nil
+    return runUploaded(h, scriptPath, opts)
 }
 
-// tryUpload first attempts rsync; on failure (e.g. Windows), falls back to SFTP.
-func tryUpload(user, password, host string, port int, localPath, remotePath string, timeout time.Duration) (bool, error) {
-    rsync := exec.Command("rsync", "-e", fmt.Sprintf("ssh -p %d", port), localPath, fmt.Sprintf("%s@%s:%s", user, host, remotePath))
-    if err := rsync.Run(); err == nil {
-        return false, nil // rsync succeeded → likely Unix
-    }
-    // fallback to SFTP
-    err := sftpUpload(user, password, host, port, timeout, localPath, remotePath)
-    return true, err // SFTP used → likely Windows
+// RunWindowsRemoteScript uploads and runs a script on a host known to be
+// Windows, without probing it.
+func RunWindowsRemoteScript(h HostInfo, scriptPath string, opts Options) Result {
+    opts.Become = false
+    h.OS = "windows"
+    return runUploaded(h, scriptPath, opts)
 }
 
-// sftpUpload pushes a file via the SFTP subsystem.
-func sftpUpload(user, password, host string, port int, timeout time.Duration, localPath, remotePath string) error {
-    conn, err := dialSSH(user, password, host, port, timeout)
-    if err != nil {
-        return err
-    }
-    defer conn.Close()
+// runUploaded puts scriptPath into a fresh remote temp dir, runs it
+// (through h's become method with opts.Become) and removes the dir again
+// unless opts.KeepRemote is set. The script is copied there from the SSH
+// user's script cache on the host, so it only crosses the network when
+// the cache does not have it yet. Everything happens on one connection,
+// and the host's platform decides how the script is uploaded, where it
+// goes, what runs it and whether it needs chmod.
+func runUploaded(h HostInfo, scriptPath string, opts Options) (res Result) {
+    start := time.Now()
 
-    client, err := sftp.NewClient(conn)
+    conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
     if err != nil {
-        return fmt.Errorf("start sftp: %v", err)
+        return failedResult(h.Host, start, err)
     }
-    defer client.Close()
-
-    dst, err := client.Create(remotePath)
-    if err != nil {
-        return fmt.Errorf("create remote file: %v", err)
-    }
-    defer dst.Close()
-
-    src, err := os.Open(localPath)
-    if err != nil {
-        return fmt.Errorf("open local file: %v", err)
-    }
-    defer src.Close()
-
-    if _, err := io.Copy(dst, src); err != nil {
-        return fmt.Errorf("copy file: %v", err)
-    }
-    return nil
-}
-
-// RunRemoteScript uploads and runs a Unix-style script (.sh, no extension, etc).
-func RunRemoteScript(user, password, host string, port int, timeout time.Duration, scriptPath string) (string, error) {
-    scriptName := filepath.Base(scriptPath)
-    remote := "/tmp/" + scriptName
+    defer conn.Close()
 
-    isWindows, err := tryUpload(user, password, host, port, scriptPath, remote, timeout)
+    platform, err := probePlatform(conn, h)
     if err != nil {
-        return "", err
+        return failedResult(h.Host, start, err)
     }
-
-    // Only chmod if it's not a Windows host
-    if !isWindows {
-        if _, err := runSSH(user, password, host, port, timeout, fmt.Sprintf("chmod +x %s", remote)); err != nil {
-            return "", fmt.Errorf("chmod failed: %v", err)
-        }
+    windows := platform.OS == "windows"
+    if res, skip := guards(conn, h, opts, windows, start); skip {
+        return res
     }
-
-    return runSSH(user, password, host, port, timeout, remote)
-}
-
-// runSSHWithPTYAndStdin requests a PTY, then runs cmd feeding stdin, and hides sudo prompt.
-func runSSHWithPTYAndStdin(
-    user, password, host string,
-    port int, timeout time.Duration,
-    cmd, stdin string,
-) (string, error) {
-    conn, err := dialSSH(user, password, host, port, timeout)
-    if err != nil {
-        return "", err
+    if opts.Check {
+        return checkUpload(conn, h, scriptPath, windows, opts, start)
     }
-    defer conn.Close()
 
-    session, err := conn.NewSession()
+    tmp, err := makeRemoteTmp(conn, h, opts, windows)
     if err != nil {
-        return "", fmt.Errorf("new session: %v", err)
+        return failedResult(h.Host, start, err)
     }
-    defer session.Close()
-
-    // request PTY so sudo can run
-    if err := session.RequestPty("xterm", 80, 40, ssh.TerminalModes{}); err != nil {
-        return "", fmt.Errorf("request pty failed: %v", err)
-    }
-
-    var stdout bytes.Buffer
-    var stderr bytes.Buffer
-
-    session.Stdout = &stdout
-    session.Stderr = &stderr
+    defer tmp.cleanup(conn, h, opts, &res)
+    remote := tmp.path(filepath.Base(scriptPath))
 
-    // use a pipe to feed password silently
-    stdinPipe, err := session.StdinPipe()
+    cached, err := cacheScript(conn, h.Host, scriptPath, filepath.Base(scriptPath), windows)
     if err != nil {
-        return "", fmt.Errorf("stdin pipe: %v", err)
-    }
-
-    // run the command
-    if err := session.Start(cmd); err != nil {
-        return "", fmt.Errorf("ssh start error: %v", err)
-    }
-
-    // write sudo password (ends with newline)
-    io.WriteString(stdinPipe, stdin)
-
-    // wait for command to finish
-    if err := session.Wait(); err != nil {
-        // if command failed, you might want stderr in the message
-        return "", fmt.Errorf("ssh error: %v, stderr: %s", err, stderr.String())
+        return failedResult(h.Host, start, fmt.Errorf("upload script: %w", err))
     }
-
-    // suppress sudo password prompt line in stderr
-    cleaned := strings.ReplaceAll(stdout.String(), stdin, "")
-    return strings.TrimSpace(cleaned), nil
-}
-
-// runSSHWithPTYAndStdin requests a PTY, then runs cmd feeding stdin.
-func RunRemoteScriptWithSudo(
-    user, sshPass, sudoPass, host string,
-    port int,
-    timeout time.Duration,
-    scriptPath string,
-) (string, error) {
-    scriptName := filepath.Base(scriptPath)
-    remote := "/tmp/" + scriptName
-
-    // upload (rsync→SFTP)
-    isWindows, err := tryUpload(user, sshPass, host, port, scriptPath, remote, timeout)
-    if err != nil {
-        return "", err
+    if err := copyFromCache(conn, h.Host, cached, remote, windows); err != nil {
+        return failedResult(h.Host, start, err)
     }
 
     // chmod only if Unix-style
-    if !isWindows {
-        if _, err := runSSH(user, sshPass, host, port, timeout,
-            fmt.Sprintf("chmod +x %s", remote),
-        ); err != nil {
-            return "", fmt.Errorf("chmod failed: %v", err)
+    if !windows {
+        if chmod := runOn(conn, h.Host, "chmod 700 "+shellQuote(remote)); chmod.Error != nil {
+            chmod.Error = fmt.Errorf("chmod failed: %w", chmod.Error)
+            return timedFrom(chmod, start)
         }
     }
 
-    // if no sudo password, run directly
-    if strings.TrimSpace(sudoPass) == "" {
-        return runSSH(user, sshPass, host, port, timeout, remote)
+    cmd := tmp.command(remote, interpreterFor(h, opts, scriptPath, windows), opts)
+    if opts.Become {
+        // run through the host's become method
+        if err := tmp.grant(conn, h, opts.BecomeUser, remote); err != nil {
+            return failedResult(h.Host, start, err)
+        }
     }
-
-    // run with sudo on Unix
-    return runSSHWithStdin(
-        user, sshPass, host, port, timeout,
-        fmt.Sprintf("sudo -S %s", remote),
-        sudoPass+"\n",
-    )
+    return timedFrom(execOn(conn, h, cmd, "", opts), start)
 }
 
-// RunWindowsRemoteScript uploads and runs a Windows batch via SFTP + cmd.
-func RunWindowsRemoteScript(user, password, host string, port int, timeout time.Duration, scriptPath string) (string, error) {
-    scriptName := filepath.Base(scriptPath)
-    remote := "C:\\tmp\\" + scriptName
-
-    // ensure C:\tmp exists
-    if _, err := runSSH(user, password, host, port, timeout,
-        `powershell -Command "if (!(Test-Path C:\\tmp)) { New-Item -ItemType Directory -Path C:\\tmp }"`); err != nil {
-        return "", fmt.Errorf("mk tmp dir: %v", err)
-    }
-
-    _, err := tryUpload(user, password, host, port, scriptPath, remote, timeout)
-    if err != nil {
-        return "", fmt.Errorf("upload script: %v", err)
-    }
-
-    return runSSH(user, password, host, port, timeout, fmt.Sprintf(`cmd /C "%s"`, remote))
+// runOn runs a command on conn and returns its result, without streaming.
+func runOn(conn *ssh.Client, host, cmd string) Result {
+    return execOn(conn, HostInfo{Host: host}, cmd, "", Options{})
 }
 
-// runSSHWithStdin runs a command feeding stdin.
-func runSSHWithStdin(user, password, host string, port int, timeout time.Duration, cmd, stdin string) (string, error) {
-    conn, err := dialSSH(user, password, host, port, timeout)
-    if err != nil {
-        return "", err
-    }
-    defer conn.Close()
-
+// execOn runs a command on a new session on conn, feeding stdin, through
+// h's become method when opts.Become is set. Output is copied to
+// opts.Stdout and opts.Stderr as it arrives, when they are set.
+func execOn(conn *ssh.Client, h HostInfo, cmd, stdin string, opts Options) Result {
+    start := time.Now()
     session, err := conn.NewSession()
     if err != nil {
-        return "", fmt.Errorf("new session: %v", err)
+        return failedResult(h.Host, start, fmt.Errorf("new session: %v", err))
     }
     defer session.Close()
 
-    var out, stderr bytes.Buffer
-    session.Stdout = &out
-    session.Stderr = &stderr
-    session.Stdin = strings.NewReader(stdin)
-
-    if err := session.Run(cmd); err != nil {
-        return "", fmt.Errorf("ssh error: %v, stderr: %s", err, stderr.String())
+    if opts.Become {
+        return execBecome(session, h, cmd, opts, start)
     }
-    return out.String(), nil
+    return execSession(session, h.Host, cmd, strings.NewReader(stdin), opts, start)
 }
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server on a random local port with a host key of
+// its own, which only gets as far as authentication.
+type testServer struct {
+	port      int
+	passwords atomic.Int32
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testServer{port: ln.Addr().(*net.TCPAddr).Port}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			s.passwords.Add(1)
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, chans, _, err := ssh.NewServerConn(conn, cfg); err == nil {
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "test server")
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// emptyHome points HOME at a folder whose known_hosts knows no host.
+func emptyHome(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+}
+
+func TestScriptRefusesUnknownHostKey(t *testing.T) {
+	emptyHome(t)
+	srv := newTestServer(t)
+	script := filepath.Join(t.TempDir(), "hi.sh")
+	if err := os.WriteFile(script, []byte("echo hi\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h := HostInfo{User: "me", Host: "127.0.0.1", Port: srv.port, Password: "pw", SudoPassword: "sudo-pw"}
+
+	res := RunRemoteScriptWithSudo(h, script, Options{})
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "key is unknown") {
+		t.Errorf("script upload to an unknown host: error %v, want the host key refused", res.Error)
+	}
+	if _, err := ProbePlatform(h, Options{}); err == nil || !strings.Contains(err.Error(), "key is unknown") {
+		t.Errorf("platform probe of an unknown host: error %v, want the host key refused", err)
+	}
+	if n := srv.passwords.Load(); n != 0 {
+		t.Errorf("the unknown host was sent the password %d times", n)
+	}
+}
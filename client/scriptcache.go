@@ -0,0 +1,168 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// localSums remembers the SHA-256 of each script by path, size and
+// modification time, so a large binary is hashed once per run rather than
+// once per host.
+var localSums struct {
+	mu   sync.Mutex
+	sums map[localSumKey]string
+}
+
+type localSumKey struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+func cachedLocalSHA256(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := localSumKey{path, info.Size(), info.ModTime()}
+
+	localSums.mu.Lock()
+	sum, ok := localSums.sums[key]
+	localSums.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	if sum, err = localSHA256(path); err != nil {
+		return "", err
+	}
+	localSums.mu.Lock()
+	if localSums.sums == nil {
+		localSums.sums = map[localSumKey]string{}
+	}
+	localSums.sums[key] = sum
+	localSums.mu.Unlock()
+	return sum, nil
+}
+
+// cacheScript makes sure the SSH user's script cache on conn holds
+// scriptPath and returns where. The cache is content addressed, as
+// <cache>/<sha256>/<name> under $XDG_CACHE_HOME/godev/scripts (~/.cache by
+// default) on Unix and %LOCALAPPDATA%\godev\scripts on Windows, so a
+// script is only sent when the host has no intact copy of this exact
+// content. What was sent is checksummed again on the host before it is
+// used.
+func cacheScript(conn *ssh.Client, host, scriptPath, name string, windows bool) (string, error) {
+	sum, err := cachedLocalSHA256(scriptPath)
+	if err != nil {
+		return "", err
+	}
+	remote, ok, err := cacheLookup(conn, host, sum, name, windows)
+	if err != nil || ok {
+		return remote, err
+	}
+
+	// A copy that is there but wrong was damaged; the checksum makes
+	// syncFile replace it whatever its size and time say.
+	if _, err := syncFile(conn, host, scriptPath, remote, windows, true); err != nil {
+		return "", err
+	}
+	if got := remoteSHA256(conn, host, remote, windows); got != sum {
+		runOn(conn, host, removeCommand(remote, windows))
+		return "", fmt.Errorf("checksum mismatch after upload (got %q)", got)
+	}
+	return remote, nil
+}
+
+// unixCacheDir is where the script cache lives on Unix hosts, for the
+// remote shell to expand.
+const unixCacheDir = "${XDG_CACHE_HOME:-$HOME/.cache}/godev/scripts"
+
+// cacheMaxAgeDays is how long a script cache entry is kept after the last
+// run that used it.
+const cacheMaxAgeDays = 7
+
+// cacheLookup creates the cache entry for content with SHA-256 sum on
+// conn, returns where a file called name goes in it and reports whether
+// an intact copy is already there, all in one round trip. The entry's
+// time is set to now, and entries no run has used for cacheMaxAgeDays
+// are deleted, so the cache does not grow with every script ever run.
+func cacheLookup(conn *ssh.Client, host, sum, name string, windows bool) (remote string, ok bool, err error) {
+	var cmd string
+	if windows {
+		q := strings.ReplaceAll(name, "'", "''")
+		cmd = `powershell -NoProfile -NonInteractive -Command "$c = Join-Path $env:LOCALAPPDATA 'godev\scripts'; $d = Join-Path $c '` + sum + `'; ` +
+			`New-Item -ItemType Directory -Force -Path $d | Out-Null; (Get-Item -LiteralPath $d).LastWriteTime = Get-Date; ` +
+			`Get-ChildItem -LiteralPath $c -Directory | Where-Object { $_.LastWriteTime -lt (Get-Date).AddDays(-` + strconv.Itoa(cacheMaxAgeDays) + `) } | ` +
+			`Remove-Item -Recurse -Force -ErrorAction SilentlyContinue; $d; $f = Join-Path $d '` + q + `'; ` +
+			`if (Test-Path -LiteralPath $f) { (Get-FileHash -Algorithm SHA256 -LiteralPath $f).Hash } else { '-' }"`
+	} else {
+		cmd = remoteHash + `umask 077; c="` + unixCacheDir + `"; d="$c/` + sum + `" && mkdir -p "$d" && touch "$d" && ` +
+			`{ find "$c" -mindepth 1 -maxdepth 1 -type d -mtime +` + strconv.Itoa(cacheMaxAgeDays) + ` -exec rm -rf {} + 2>/dev/null; ` +
+			`echo "$d"; } && ` +
+			`if [ -f "$d"/` + shellQuote(name) + ` ]; then h < "$d"/` + shellQuote(name) + `; else echo -; fi`
+	}
+	res := runOn(conn, host, cmd)
+	if res.Error != nil {
+		return "", false, fmt.Errorf("script cache: %w", res.Error)
+	}
+	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	if len(lines) != 2 {
+		return "", false, fmt.Errorf("script cache: unexpected output %q", strings.TrimSpace(res.Stdout))
+	}
+	remote = lines[0] + "/" + name
+	if windows {
+		remote = lines[0] + `\` + name
+	}
+	return remote, strings.ToLower(lines[1]) == sum, nil
+}
+
+// cacheHas reports whether conn's script cache already holds an intact
+// copy of the content with SHA-256 sum, called name. Unlike cacheLookup it
+// only reads: nothing is created, touched or pruned.
+func cacheHas(conn *ssh.Client, host, sum, name string, windows bool) (bool, error) {
+	var cmd string
+	if windows {
+		q := strings.ReplaceAll(name, "'", "''")
+		cmd = `powershell -NoProfile -NonInteractive -Command "$f = Join-Path $env:LOCALAPPDATA 'godev\scripts\` + sum + `\` + q + `'; ` +
+			`if (Test-Path -LiteralPath $f) { (Get-FileHash -Algorithm SHA256 -LiteralPath $f).Hash } else { '-' }"`
+	} else {
+		cmd = remoteHash + `f="` + unixCacheDir + `/` + sum + `"/` + shellQuote(name) + `; ` +
+			`if [ -f "$f" ]; then h < "$f"; else echo -; fi`
+	}
+	res := runOn(conn, host, cmd)
+	if res.Error != nil {
+		return false, fmt.Errorf("script cache: %w", res.Error)
+	}
+	return strings.ToLower(strings.TrimSpace(res.Stdout)) == sum, nil
+}
+
+// copyFromCache puts a copy of cached, a script cache entry, at remote.
+func copyFromCache(conn *ssh.Client, host, cached, remote string, windows bool) error {
+	cmd := "cp " + shellQuote(cached) + " " + shellQuote(remote)
+	if windows {
+		cmd = `powershell -NoProfile -NonInteractive -Command "Copy-Item -LiteralPath '` + strings.ReplaceAll(cached, "'", "''") +
+			`' -Destination '` + strings.ReplaceAll(remote, "'", "''") + `'"`
+	}
+	if res := runOn(conn, host, cmd); res.Error != nil {
+		return fmt.Errorf("copy from script cache: %w", res.Error)
+	}
+	return nil
+}
+
+// removeCommand is the command that deletes the file at remote.
+func removeCommand(remote string, windows bool) string {
+	if windows {
+		return `powershell -NoProfile -NonInteractive -Command "Remove-Item -LiteralPath '` + strings.ReplaceAll(remote, "'", "''") + `' -Force"`
+	}
+	return "rm -f " + shellQuote(remote)
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Shell is a persistent sh on one host, for godev shell. Commands given to
+// Run go to the same shell one after another, so cd, variables and
+// functions carry over from one command to the next. Commands run as the
+// SSH user with no terminal and no stdin.
+type Shell struct {
+	host    HostInfo
+	conn    *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	marker  string
+	stdout  *shellWatcher
+	stderr  *shellWatcher
+	closed  chan struct{}
+	err     error
+}
+
+// OpenShell connects to h and starts a shell there. opts.Stdout and
+// opts.Stderr receive everything the shell prints for as long as it is
+// open; opts.SkipCapture leaves it out of the Results of Run.
+func OpenShell(h HostInfo, opts Options) (*Shell, error) {
+	if h.OS == "windows" {
+		return nil, fmt.Errorf("godev shell needs a POSIX sh, and %s is a Windows host", h.Host)
+	}
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return nil, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("new SSH session: %w", err)
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s := &Shell{
+		host:    h,
+		conn:    conn,
+		session: session,
+		marker:  "GODEV-SHELL-" + hex.EncodeToString(nonce),
+		closed:  make(chan struct{}),
+	}
+	s.stdout = newShellWatcher(s.marker, opts.Stdout, opts.SkipCapture)
+	s.stderr = newShellWatcher(s.marker, opts.Stderr, opts.SkipCapture)
+	session.Stdout = s.stdout
+	session.Stderr = s.stderr
+	if s.stdin, err = session.StdinPipe(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("stdin pipe: %v", err)
+	}
+	if err := session.Start("sh"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("start shell: %v", err)
+	}
+	go func() {
+		s.err = session.Wait()
+		close(s.closed)
+	}()
+
+	// A shell that answers a no-op is ready for real commands.
+	if res := s.Run("true"); res.Error != nil || res.ExitCode != 0 {
+		s.Close()
+		if res.Error == nil {
+			res.Error = fmt.Errorf("exit status %d", res.ExitCode)
+		}
+		return nil, fmt.Errorf("start shell: %w", res.Error)
+	}
+	return s, nil
+}
+
+// Run runs cmd in the shell and waits for it to finish. A command that
+// exits non-zero is not an error; the Result's ExitCode says how it went.
+// Result.Error is set when the shell itself is gone, because the command
+// ran exit, the connection dropped or Close was called meanwhile.
+func (s *Shell) Run(cmd string) Result {
+	start := time.Now()
+	s.stdout.begin()
+	s.stderr.begin()
+
+	// command eval keeps a syntax error in cmd from ending the shell, and
+	// the marker on both streams says when all of cmd's output is in.
+	script := "command eval " + shellQuote(cmd) + " </dev/null\n" +
+		"printf '%s %d\\n' " + s.marker + " $?\n" +
+		"printf '%s\\n' " + s.marker + " >&2\n"
+	res := Result{Host: s.host.Host, StartedAt: start, ExitCode: -1}
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		res.Error = s.gone(err)
+	} else {
+		select {
+		case res.ExitCode = <-s.stdout.done:
+			select {
+			case <-s.stderr.done:
+			case <-s.closed:
+				res.Error = s.gone(nil)
+			}
+		case <-s.closed:
+			res.Error = s.gone(nil)
+		}
+	}
+
+	res.Stdout = s.stdout.captured()
+	res.Stderr = s.stderr.captured()
+	res.Output = res.Stdout
+	res.Duration = time.Since(start)
+	return redactResult(res)
+}
+
+// gone explains why the shell stopped taking commands.
+func (s *Shell) gone(err error) error {
+	select {
+	case <-s.closed:
+		if s.err != nil {
+			return fmt.Errorf("shell ended: %v", s.err)
+		}
+		return fmt.Errorf("shell ended")
+	default:
+		return fmt.Errorf("send command: %v", err)
+	}
+}
+
+// Close ends the shell and its connection, stopping any command that is
+// still running.
+func (s *Shell) Close() error {
+	s.session.Close()
+	return s.conn.Close()
+}
+
+// shellWatcher sits on one of a Shell's output streams. It passes output
+// on until the marker that ends a command shows up, holding back as much
+// as could be the start of one, and reports what followed the marker on
+// its line on done. Secrets are masked in what is passed on.
+type shellWatcher struct {
+	mu      sync.Mutex
+	marker  []byte
+	out     io.Writer
+	stream  *redactWriter
+	capture bytes.Buffer
+	pending []byte
+	done    chan int
+}
+
+func newShellWatcher(marker string, w io.Writer, skipCapture bool) *shellWatcher {
+	sw := &shellWatcher{marker: []byte(marker), done: make(chan int, 1)}
+	if w == nil {
+		sw.out = captureWriter(&sw.capture, nil, skipCapture)
+		return sw
+	}
+	sw.stream = &redactWriter{w: w}
+	sw.out = captureWriter(&sw.capture, sw.stream, skipCapture)
+	return sw
+}
+
+// begin clears what the previous command captured.
+func (w *shellWatcher) begin() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.capture.Reset()
+}
+
+func (w *shellWatcher) captured() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.capture.String()
+}
+
+func (w *shellWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+
+	for {
+		i := bytes.Index(w.pending, w.marker)
+		if i < 0 {
+			break
+		}
+		end := bytes.IndexByte(w.pending[i:], '\n')
+		if end < 0 {
+			// Wait for the rest of the marker's line.
+			if _, err := w.out.Write(w.pending[:i]); err != nil {
+				return 0, err
+			}
+			w.pending = append(w.pending[:0], w.pending[i:]...)
+			return len(p), nil
+		}
+		if _, err := w.out.Write(w.pending[:i]); err != nil {
+			return 0, err
+		}
+		code, _ := strconv.Atoi(strings.TrimSpace(string(w.pending[i+len(w.marker) : i+end])))
+		w.pending = append(w.pending[:0], w.pending[i+end+1:]...)
+		if w.stream != nil {
+			w.stream.Flush()
+		}
+		select {
+		case w.done <- code:
+		default:
+		}
+	}
+
+	keep := len(w.marker) - 1
+	if keep > len(w.pending) {
+		keep = len(w.pending)
+	}
+	for keep > 0 && !bytes.HasPrefix(w.marker, w.pending[len(w.pending)-keep:]) {
+		keep--
+	}
+	if _, err := w.out.Write(w.pending[:len(w.pending)-keep]); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[len(w.pending)-keep:]...)
+	return len(p), nil
+}
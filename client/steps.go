@@ -0,0 +1,125 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SplitSteps breaks the contents of a commands file into steps. If any
+// line is exactly "---" the file is split into blocks at those lines;
+// otherwise every line is its own step. Blank lines and lines that are
+// only a comment do not make steps of their own.
+func SplitSteps(content string) []string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	blocks := false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "---" {
+			blocks = true
+			break
+		}
+	}
+
+	var steps, block []string
+	flush := func() {
+		if len(block) > 0 {
+			steps = append(steps, strings.Join(block, "\n"))
+			block = nil
+		}
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "---":
+			flush()
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case blocks:
+			block = append(block, line)
+		default:
+			steps = append(steps, line)
+		}
+	}
+	flush()
+	return steps
+}
+
+// RunSteps runs each step in its own session on one connection to h, so
+// every step gets its own exit status and timing, and none sees the
+// working directory or variables another one set. After a failure the
+// remaining steps are skipped when stopOnError is set, and run anyway
+// otherwise. The Result's output is the steps' output in order, and its
+// exit status and error are those of the first failed step.
+func RunSteps(h HostInfo, steps []string, stopOnError bool, opts Options) Result {
+	start := time.Now()
+
+	conn, err := connectClient(h.User, h.Password, h.Host, h.Port, opts.Timeout, opts.AllowUnknownHosts)
+	if err != nil {
+		return failedResult(h.Host, start, err)
+	}
+	defer conn.Close()
+	if res, skip := unprobedGuards(conn, h, opts, start); skip {
+		return res
+	}
+	if opts.Check {
+		return checkScript(conn, h, steps, opts, start)
+	}
+
+	res := Result{Host: h.Host, StartedAt: start}
+	var stdout, stderr strings.Builder
+	for i, step := range steps {
+		if res.FailedStep > 0 && stopOnError {
+			res.Steps = append(res.Steps, StepResult{Command: step, ExitCode: -1, Skipped: true})
+			continue
+		}
+
+		stepStart := time.Now()
+		var sr Result
+		session, err := conn.NewSession()
+		if err != nil {
+			sr = failedResult(h.Host, stepStart, fmt.Errorf("new session: %w", err))
+		} else {
+			if opts.Become {
+				sr = execBecome(session, h, step, opts, stepStart)
+			} else {
+				sr = execSession(session, h.Host, step, nil, opts, stepStart)
+			}
+			session.Close()
+		}
+
+		res.Steps = append(res.Steps, StepResult{
+			Command:   step,
+			ExitCode:  sr.ExitCode,
+			Signal:    sr.Signal,
+			Stdout:    sr.Stdout,
+			Stderr:    sr.Stderr,
+			Error:     sr.Error,
+			StartedAt: sr.StartedAt,
+			Duration:  sr.Duration,
+		})
+		stdout.WriteString(sr.Stdout)
+		stderr.WriteString(sr.Stderr)
+
+		if sr.Error != nil && res.FailedStep == 0 {
+			res.FailedStep = i + 1
+			res.ExitCode = sr.ExitCode
+			res.Signal = sr.Signal
+			res.Error = fmt.Errorf("step %d (%s) failed: %w", i+1, StepLabel(step), sr.Error)
+		}
+	}
+
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	res.Output = res.Stdout
+	res.Duration = time.Since(start)
+	return redactResult(res)
+}
+
+// StepLabel shortens a step to its first line for use in messages.
+func StepLabel(step string) string {
+	label, _, multi := strings.Cut(strings.TrimSpace(step), "\n")
+	if multi {
+		label += " ..."
+	}
+	return label
+}
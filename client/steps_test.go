@@ -0,0 +1,26 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSteps(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"", nil},
+		{"uptime\n\n# a comment\ndf -h\n", []string{"uptime", "df -h"}},
+		{"uptime\r\ndf -h\r\n", []string{"uptime", "df -h"}},
+		// A --- line anywhere turns the whole file into blocks.
+		{"cd /tmp\nls\n---\n  # setup done\nuptime\n", []string{"cd /tmp\nls", "uptime"}},
+		{"---\nls\n ---\n---\nuptime\n---\n", []string{"ls", "uptime"}},
+		{"  indented\n", []string{"  indented"}},
+	}
+	for _, tt := range tests {
+		if got := SplitSteps(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitSteps(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
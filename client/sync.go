@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// syncBlockSize is the unit in which large files are compared and patched.
+const syncBlockSize = 64 * 1024
+
+// deltaMinSize is the smallest file worth patching block by block rather
+// than sending whole.
+const deltaMinSize = 1 << 20
+
+// remoteHash is a POSIX shell function that prints the SHA-256 of its
+// stdin with whatever tool the host has.
+const remoteHash = `h() { if command -v sha256sum >/dev/null 2>&1; then sha256sum | cut -d' ' -f1; ` +
+	`elif command -v shasum >/dev/null 2>&1; then shasum -a 256 | cut -d' ' -f1; ` +
+	`elif command -v sha256 >/dev/null 2>&1; then sha256 -q; ` +
+	`else openssl dgst -sha256 | sed 's/.*= *//'; fi; }; `
+
+// blockSums is a shell command that prints the SHA-256 of every
+// syncBlockSize block of its stdin, one per line, in a single pass by one
+// process. It needs perl, whose Digest::SHA has shipped with it since
+// 5.10, or python3, and fails on hosts with neither.
+var blockSums = `if command -v perl >/dev/null 2>&1 && perl -MDigest::SHA -e1 2>/dev/null; then ` +
+	`perl -MDigest::SHA=sha256_hex -e 'binmode STDIN; while (read(STDIN, my $b, ` + strconv.Itoa(syncBlockSize) + `)) { print sha256_hex($b), "\n" }'; ` +
+	`elif command -v python3 >/dev/null 2>&1; then ` +
+	`python3 -c 'import sys, hashlib` + "\n" +
+	`for b in iter(lambda: sys.stdin.buffer.read(` + strconv.Itoa(syncBlockSize) + `), b""): print(hashlib.sha256(b).hexdigest())'; ` +
+	`else exit 127; fi`
+
+// syncFile makes remotePath on conn a copy of localPath, keeping its mode
+// and modification time, and reports whether anything had to be sent. A
+// remote file whose size and modification time match (or, with checksum,
+// whose SHA-256 matches) is left alone. Large files that changed are
+// patched one block at a time where the host has perl or python3 to hash
+// its copy's blocks with; anything else is sent whole. Either way the new
+// content is written next to remotePath and renamed over it, so nothing
+// ever sees a half-written file.
+func syncFile(conn *ssh.Client, host, localPath, remotePath string, windows, checksum bool) (bool, error) {
+	local, err := os.Stat(localPath)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return false, fmt.Errorf("start sftp: %v", err)
+	}
+	defer client.Close()
+
+	remote, err := client.Stat(remotePath)
+	exists := err == nil && remote.Mode().IsRegular()
+	if exists && remote.Size() == local.Size() {
+		same := remote.ModTime().Unix() == local.ModTime().Unix()
+		if checksum {
+			same = false
+			if sum, err := localSHA256(localPath); err == nil {
+				same = remoteSHA256(conn, host, remotePath, windows) == sum
+			}
+		}
+		if same {
+			return false, nil
+		}
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return false, err
+	}
+	tmp := remotePath + ".godev-" + hex.EncodeToString(suffix)
+
+	sent := false
+	if exists && !windows && local.Size() >= deltaMinSize && remote.Size() >= deltaMinSize {
+		sent = sendDelta(conn, client, host, localPath, remotePath, tmp, local.Size(), remote.Size()) == nil
+	}
+	if !sent {
+		if err := sendWhole(client, localPath, tmp); err != nil {
+			client.Remove(tmp)
+			return false, err
+		}
+	}
+
+	if !windows {
+		if err := client.Chmod(tmp, local.Mode().Perm()); err != nil {
+			client.Remove(tmp)
+			return false, fmt.Errorf("chmod remote file: %v", err)
+		}
+	}
+	if err := client.Chtimes(tmp, local.ModTime(), local.ModTime()); err != nil {
+		client.Remove(tmp)
+		return false, fmt.Errorf("set remote file time: %v", err)
+	}
+	if err := renameOver(client, tmp, remotePath); err != nil {
+		client.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// sendWhole copies localPath to remotePath.
+func sendWhole(client *sftp.Client, localPath, remotePath string) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open local file: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := client.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("create remote file: %v", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("copy file: %v", err)
+	}
+	return dst.Close()
+}
+
+// sendDelta writes tmp as a copy of the existing remotePath with only the
+// blocks that differ from localPath replaced. Any error means the caller
+// should send the file whole instead.
+func sendDelta(conn *ssh.Client, client *sftp.Client, host, localPath, remotePath, tmp string, localSize, remoteSize int64) error {
+	blocks := (remoteSize + syncBlockSize - 1) / syncBlockSize
+	script := "f=" + shellQuote(remotePath) + "; " + blockSums + " < \"$f\" && cp -p \"$f\" " + shellQuote(tmp)
+	res := runOn(conn, host, script)
+	if res.Error != nil {
+		return res.Error
+	}
+	remoteSums := strings.Fields(res.Stdout)
+	if int64(len(remoteSums)) != blocks {
+		return fmt.Errorf("block helper printed %d sums for %d blocks", len(remoteSums), blocks)
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := client.OpenFile(tmp, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	buf := make([]byte, syncBlockSize)
+	r := bufio.NewReaderSize(src, syncBlockSize)
+	for off, i := int64(0), 0; off < localSize; off, i = off+syncBlockSize, i+1 {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		block := buf[:n]
+		sum := sha256.Sum256(block)
+		if i < len(remoteSums) && remoteSums[i] == hex.EncodeToString(sum[:]) {
+			continue
+		}
+		if _, err := dst.WriteAt(block, off); err != nil {
+			return err
+		}
+	}
+	if localSize < remoteSize {
+		return dst.Truncate(localSize)
+	}
+	return nil
+}
+
+// renameOver moves from to to, replacing to. Servers without the
+// posix-rename extension (Windows among them) refuse to rename over an
+// existing file, so there to is removed first.
+func renameOver(client *sftp.Client, from, to string) error {
+	if err := client.PosixRename(from, to); err == nil {
+		return nil
+	}
+	client.Remove(to)
+	if err := client.Rename(from, to); err != nil {
+		return fmt.Errorf("rename remote file: %v", err)
+	}
+	return nil
+}
+
+// localSHA256 returns the hex SHA-256 of the file at path.
+func localSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteSHA256 returns the hex SHA-256 of path on conn, or "" when it
+// cannot be worked out.
+func remoteSHA256(conn *ssh.Client, host, path string, windows bool) string {
+	cmd := remoteHash + "h < " + shellQuote(path)
+	if windows {
+		cmd = `powershell -NoProfile -NonInteractive -Command "(Get-FileHash -Algorithm SHA256 -LiteralPath '` +
+			strings.ReplaceAll(path, "'", "''") + `').Hash"`
+	}
+	res := runOn(conn, host, cmd)
+	if res.Error != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(res.Stdout))
+}
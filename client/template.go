@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Facts are what godev finds out about a host for templates to use.
+type Facts struct {
+	OS       string
+	Arch     string
+	Hostname string
+	Kernel   string
+	CPUs     int
+}
+
+// TemplateData is what a copy template is rendered with: the host as the
+// inventory names it, its inventory vars and the facts gathered from it.
+// Passwords are deliberately left out.
+type TemplateData struct {
+	Host  string
+	User  string
+	Port  int
+	Vars  map[string]string
+	Facts Facts
+}
+
+// gatherFacts asks h over conn for its hostname, kernel release and CPU
+// count, on top of its platform.
+func gatherFacts(conn *ssh.Client, h HostInfo, p Platform) (Facts, error) {
+	f := Facts{OS: p.OS, Arch: p.Arch}
+	if p.OS == "windows" {
+		res := runOn(conn, h.Host, `cmd /C "hostname & ver & echo %NUMBER_OF_PROCESSORS%"`)
+		if res.Error != nil {
+			return f, fmt.Errorf("gather facts: %w", res.Error)
+		}
+		var lines []string
+		for _, line := range strings.Split(res.Stdout, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) != 3 {
+			return f, fmt.Errorf("gather facts: unexpected output %q", strings.TrimSpace(res.Stdout))
+		}
+		f.Hostname = lines[0]
+		if _, v, ok := strings.Cut(lines[1], "Version "); ok {
+			f.Kernel = strings.TrimSuffix(v, "]")
+		}
+		f.CPUs, _ = strconv.Atoi(lines[2])
+		return f, nil
+	}
+
+	res := runOn(conn, h.Host, "uname -n; uname -r; nproc 2>/dev/null || getconf _NPROCESSORS_ONLN 2>/dev/null || echo 0")
+	if res.Error != nil {
+		return f, fmt.Errorf("gather facts: %w", res.Error)
+	}
+	lines := strings.Fields(res.Stdout)
+	if len(lines) != 3 {
+		return f, fmt.Errorf("gather facts: unexpected output %q", strings.TrimSpace(res.Stdout))
+	}
+	f.Hostname, f.Kernel = lines[0], lines[1]
+	f.CPUs, _ = strconv.Atoi(lines[2])
+	return f, nil
+}
+
+// parseTemplate reads the template in the file at path. A key missing
+// from .Vars is an error rather than an empty string, so a typo cannot
+// quietly end up on every host.
+func parseTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(data))
+}
+
+// CheckTemplates parses every file copy --template would render from src,
+// so syntax errors are caught once rather than on every host.
+func CheckTemplates(src string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		_, err = parseTemplate(p)
+		return err
+	})
+}
+
+// renderTemplates renders every file in items with data into a new local
+// temp dir and points the items at the results. The caller removes dir.
+func renderTemplates(items []copyItem, data TemplateData) (dir string, err error) {
+	dir, err = os.MkdirTemp("", "godev-render-")
+	if err != nil {
+		return "", err
+	}
+	for i := range items {
+		if items[i].dir {
+			continue
+		}
+		t, err := parseTemplate(items[i].local)
+		if err != nil {
+			return dir, err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			return dir, err
+		}
+		out := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(out, buf.Bytes(), 0o600); err != nil {
+			return dir, err
+		}
+		items[i].local = out
+	}
+	return dir, nil
+}
@@ -1,15 +1,104 @@
 package client
 
+import (
+	"io"
+	"time"
+)
+
+// HostInfo is one host to run against. BecomeMethod, Interpreter, OS and
+// Vars come from key=value attributes in the inventory; BecomeMethod and
+// Interpreter override the run's Options for this host, and OS overrides
+// what probing the host would say. LocalName, set by NameHosts, is what
+// files kept on this machine for the host are called.
 type HostInfo struct {
 	User string
 	Host string
 	Port int
 	Password string
 	SudoPassword string
+	BecomeMethod string
+	Interpreter string
+	OS string
+	Vars map[string]string
+	LocalName string
+}
+
+// Options holds the settings shared by every host in a run. Stdout and
+// Stderr, when set, receive remote output as it arrives, in addition to it
+// being captured in the Result. SkipCapture leaves Result.Stdout and
+// Result.Stderr empty, for callers that only want the streamed copy and
+// would rather not hold large outputs in memory. Become runs commands
+// with privileges gained through BecomeMethod (sudo when unset) using the
+// host's sudo password, as BecomeUser when set and the tool's default
+// user, normally root, otherwise. Uploaded scripts go into a fresh private
+// directory under RemoteTmp (the remote user's temp directory when unset),
+// which is removed afterwards unless KeepRemote is set. The script is run
+// with Args as its arguments and Env, a list of KEY=VALUE pairs, added to
+// its environment, by Interpreter when set and otherwise by whatever
+// suits its extension. Check connects and reports what would be done, in
+// the Result's Stdout, without changing anything on the host. Creates,
+// Unless and OnlyIf guard commands and scripts: the host is skipped when
+// the Creates path exists, when Unless exits 0 or when OnlyIf does not.
+// FromCache has Copy look for each file it sends in the host's script
+// cache, where Distribute may have put it, before uploading it.
+type Options struct {
+	Timeout           time.Duration
+	AllowUnknownHosts bool
+	Become            bool
+	BecomeMethod      string
+	BecomeUser        string
+	Stdout            io.Writer
+	Stderr            io.Writer
+	SkipCapture       bool
+	RemoteTmp         string
+	KeepRemote        bool
+	Args              []string
+	Env               []string
+	Interpreter       string
+	Check             bool
+	Creates           string
+	Unless            string
+	OnlyIf            string
+	FromCache         bool
 }
 
+// Result is the outcome of running something on one host. User and Port
+// are filled in by the caller that knows which HostInfo produced it.
+// Output mirrors Stdout for callers that only care about regular output.
+// ExitCode is -1 when the remote command never reported a status, e.g.
+// because the connection failed or the command was killed by a signal.
 type Result struct {
-	Host   string
-	Output string
-	Error  error
+	Host      string
+	User      string
+	Port      int
+	Output    string
+	Error     error
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+	Signal    string
+	StartedAt time.Time
+	Duration  time.Duration
+	// Steps and FailedStep are only set by RunSteps. FailedStep is the
+	// 1-based index of the first step that failed, or 0.
+	Steps      []StepResult
+	FailedStep int
+	// Status is set by operations that can succeed without doing
+	// anything, such as Copy: "changed" or "unchanged", or "skipped"
+	// when a guard in Options kept anything from running.
+	Status string
+}
+
+// StepResult is the outcome of one step of a RunSteps run. Skipped steps
+// were never started because an earlier one failed.
+type StepResult struct {
+	Command   string
+	ExitCode  int
+	Signal    string
+	Stdout    string
+	Stderr    string
+	Error     error
+	Skipped   bool
+	StartedAt time.Time
+	Duration  time.Duration
 }
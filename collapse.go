@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"godev/client"
+)
+
+// outputGroup is a set of hosts that produced byte-identical output and
+// finished the same way.
+type outputGroup struct {
+	hosts []string
+	res   client.Result
+}
+
+type groupKey struct {
+	stdout, stderr, signal, err string
+	exitCode                    int
+}
+
+// groupResults buckets results by output and exit status, largest group
+// first.
+func groupResults(results []client.Result) []*outputGroup {
+	byKey := map[groupKey]*outputGroup{}
+	var groups []*outputGroup
+	for _, res := range results {
+		k := groupKey{
+			stdout:   res.Stdout,
+			stderr:   res.Stderr,
+			signal:   res.Signal,
+			exitCode: res.ExitCode,
+		}
+		if res.Error != nil {
+			k.err = res.Error.Error()
+		}
+		g, ok := byKey[k]
+		if !ok {
+			g = &outputGroup{res: res}
+			byKey[k] = g
+			groups = append(groups, g)
+		}
+		g.hosts = append(g.hosts, res.Host)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].hosts) > len(groups[j].hosts)
+	})
+	return groups
+}
+
+// groupText is what a group prints and what --collapse-diff compares.
+func groupText(res client.Result) string {
+	text := res.Stdout
+	if res.Stderr != "" {
+		text += res.Stderr
+	}
+	if res.Error != nil {
+		text += fmt.Sprintf("%v\n", res.Error)
+	}
+	return text
+}
+
+// printCollapsed prints each group once under its compressed host list.
+// With showDiff, every group after the first is shown as a diff against
+// the majority output instead of in full.
+func printCollapsed(w io.Writer, results []client.Result, showDiff bool) {
+	groups := groupResults(results)
+	for i, g := range groups {
+		hostList := compressHosts(g.hosts)
+		fmt.Fprintf(w, "======================================\n")
+		if g.res.Error != nil {
+			fmt.Fprintf(w, "------ Error with %d host(s) %s -----\n", len(g.hosts), hostList)
+		} else {
+			fmt.Fprintf(w, "----- Output from %d host(s) %s -----\n", len(g.hosts), hostList)
+		}
+		fmt.Fprintf(w, "======================================\n\n")
+
+		if showDiff && i > 0 {
+			majority := groups[0]
+			fmt.Fprintf(w, "%s\n", client.UnifiedDiff(
+				groupText(majority.res), groupText(g.res),
+				compressHosts(majority.hosts), hostList,
+			))
+			continue
+		}
+		if g.res.Error != nil {
+			fmt.Fprintf(w, "%v\n", g.res.Error)
+			if g.res.Stdout != "" {
+				fmt.Fprintf(w, "stdout:\n%s\n", g.res.Stdout)
+			}
+			if g.res.Stderr != "" {
+				fmt.Fprintf(w, "stderr:\n%s\n", g.res.Stderr)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", g.res.Stdout)
+		if g.res.Stderr != "" {
+			fmt.Fprintf(w, "%s\n", g.res.Stderr)
+		}
+	}
+}
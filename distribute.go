@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"godev/client"
+)
+
+// distribute loads what the run is about to upload into every host's
+// script cache before the run starts, with client.Distribute, so it leaves
+// this machine only fanout times per file. That is the --script itself,
+// the binary built for each host's platform, or every file under the copy
+// source. Hosts it could not reach are left to the run, which uploads to
+// them directly as usual.
+func distribute(hosts []client.HostInfo, cfg *runConfig, fanout int) {
+	groups := map[string][]client.HostInfo{}
+	switch {
+	case cfg.copySrc != "":
+		var files []string
+		filepath.WalkDir(cfg.copySrc, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		for _, f := range files {
+			groups[f] = hosts
+		}
+	case cfg.builds != nil:
+		// Each host's platform is probed over SSH, so that is done
+		// workerCount hosts at a time. Hosts whose build fails are left for
+		// the run to report.
+		binaries := make([]string, len(hosts))
+		jobs := make(chan int, len(hosts))
+		for i := range hosts {
+			jobs <- i
+		}
+		close(jobs)
+		var wg sync.WaitGroup
+		for w := 0; w < workerCount; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					binaries[i], _ = cfg.builds.forHost(hosts[i], cfg.opts)
+				}
+			}()
+		}
+		wg.Wait()
+		for i, h := range hosts {
+			if binaries[i] != "" {
+				groups[binaries[i]] = append(groups[binaries[i]], h)
+			}
+		}
+	case cfg.scriptUsed:
+		groups[cfg.scriptArg] = hosts
+	}
+
+	paths := make([]string, 0, len(groups))
+	for p := range groups {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		errs, hopErrs := client.Distribute(groups[p], p, fanout, cfg.opts)
+		reached := 0
+		for i, err := range errs {
+			if err == nil {
+				reached++
+			}
+			if hopErrs[i] != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] %s: fan-out failed, sent %s directly: %v\n",
+					groups[p][i].Host, filepath.Base(p), client.Redact(hopErrs[i].Error()))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Fan-out: %s reached %d of %d host(s)\n", p, reached, len(errs))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// compressHosts folds host names that differ only in a number into
+// pdsh-style ranges, e.g. web01, web02, web03 and web07 become
+// "web[01-03,07]". As in pdsh, only numbers of the same width share a
+// range: a zero padded number sets its width, and an unpadded one joins
+// padded numbers of its own length or else the other unpadded ones, so
+// web1 and web02 stay apart while web9 and web10 fold into web[9-10].
+// Names without digits are listed as they are.
+func compressHosts(hosts []string) string {
+	type key struct {
+		prefix, suffix string
+		width          int
+	}
+	type entry struct {
+		prefix, digits, suffix string
+	}
+	var entries []entry
+	var plain []string
+	padded := map[int]bool{}
+
+	for _, h := range hosts {
+		end := strings.LastIndexAny(h, "0123456789")
+		if end < 0 {
+			plain = append(plain, h)
+			continue
+		}
+		start := end
+		for start > 0 && h[start-1] >= '0' && h[start-1] <= '9' {
+			start--
+		}
+		e := entry{prefix: h[:start], digits: h[start : end+1], suffix: h[end+1:]}
+		if len(e.digits) > 1 && e.digits[0] == '0' {
+			padded[len(e.digits)] = true
+		}
+		entries = append(entries, e)
+	}
+
+	groups := map[key][]int{}
+	var keys []key
+	var out []string
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.digits)
+		if err != nil {
+			out = append(out, e.prefix+e.digits+e.suffix)
+			continue
+		}
+		// Width 0 is the unpadded numbers, printed as they are.
+		k := key{prefix: e.prefix, suffix: e.suffix}
+		if padded[len(e.digits)] {
+			k.width = len(e.digits)
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], n)
+	}
+
+	for _, k := range keys {
+		nums := groups[k]
+		if len(nums) == 1 {
+			out = append(out, fmt.Sprintf("%s%0*d%s", k.prefix, k.width, nums[0], k.suffix))
+			continue
+		}
+		sort.Ints(nums)
+		var ranges []string
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] <= nums[j]+1 {
+				j++
+			}
+			if nums[i] == nums[j] {
+				ranges = append(ranges, fmt.Sprintf("%0*d", k.width, nums[i]))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%0*d-%0*d", k.width, nums[i], k.width, nums[j]))
+			}
+			i = j + 1
+		}
+		out = append(out, fmt.Sprintf("%s[%s]%s", k.prefix, strings.Join(ranges, ","), k.suffix))
+	}
+	out = append(out, plain...)
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
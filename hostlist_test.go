@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestCompressHosts(t *testing.T) {
+	tests := []struct {
+		hosts []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"db"}, "db"},
+		{[]string{"web1"}, "web1"},
+		{[]string{"web3", "web1", "web2"}, "web[1-3]"},
+		{[]string{"web01", "web02", "web03", "web07"}, "web[01-03,07]"},
+		// Only numbers of the same width share a range.
+		{[]string{"web09", "web10"}, "web[09-10]"},
+		{[]string{"web1", "web02"}, "web02,web1"},
+		{[]string{"web1", "web02", "web03", "web2"}, "web[02-03],web[1-2]"},
+		{[]string{"web9", "web10", "web11"}, "web[9-11]"},
+		{[]string{"web001", "web01", "web1"}, "web001,web01,web1"},
+		// Only the last number in a name is folded.
+		{[]string{"r1.dc1", "r2.dc1", "r1.dc2"}, "r1.dc[1-2],r2.dc1"},
+		{[]string{"web2", "db", "web1", "cache"}, "cache,db,web[1-2]"},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}, "10.0.0.[1-2,4]"},
+	}
+	for _, tt := range tests {
+		if got := compressHosts(tt.hosts); got != tt.want {
+			t.Errorf("compressHosts(%q) = %q, want %q", tt.hosts, got, tt.want)
+		}
+	}
+}
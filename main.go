@@ -3,6 +3,8 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -46,12 +48,55 @@ func splitUnescaped(s string, sep string) []string {
 	return parts
 }
 
+// splitAttributes peels the key=value attributes off the end of an
+// inventory line, returning the host entry before them with its escapes
+// still in place. Only trailing fields that look like attributes are taken,
+// so a password with a space in it stays part of the host entry.
+func splitAttributes(line string) (string, []string) {
+	var attrs []string
+	for {
+		// Find where the last unescaped space or tab ends.
+		cut := -1
+		escaped := false
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			if !escaped && (c == ' ' || c == '\t') {
+				cut = i
+			}
+			escaped = c == '\\' && !escaped
+		}
+		if cut < 0 || !isAttribute(line[cut+1:]) {
+			return line, attrs
+		}
+		attrs = append([]string{line[cut+1:]}, attrs...)
+		line = strings.TrimRight(line[:cut], " \t")
+	}
+}
+
+// isAttribute reports whether field has the form key=value, where key is
+// a name or secret:name.
+func isAttribute(field string) bool {
+	key, _, ok := strings.Cut(field, "=")
+	key = strings.TrimPrefix(key, "secret:")
+	if !ok || key == "" {
+		return false
+	}
+	for i, c := range key {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
 func unescapeField(s string) string {
 	replacer := strings.NewReplacer(
 		`\\`, `\`,
 		`\@`, `@`,
 		`\:`, `:`,
 		`\#`, `#`,
+		`\ `, ` `,
 	)
 	return replacer.Replace(s)
 }
@@ -84,16 +129,24 @@ func parseInventoryLine(raw string, defUser string, defPort int) (client.HostInf
 		Port: defPort,
 	}
 
+	// Anything after the host entry is a key=value attribute. Errors
+	// name what was wrong but never echo the line, which may hold
+	// passwords, and the line's secrets are registered before anything
+	// else can fail.
+	line, attrs := splitAttributes(line)
+
 	parts := splitUnescaped(line, ":::")
 	if len(parts) == 2 {
 		line = parts[0]
 		info.SudoPassword = unescapeField(parts[1])
+		client.AddSecret(info.SudoPassword)
 	}
 
 	parts = splitUnescaped(line, "::")
 	if len(parts) == 2 {
 		line = parts[0]
 		info.Password = unescapeField(parts[1])
+		client.AddSecret(info.Password)
 	}
 
 	parts = splitUnescaped(line, "@")
@@ -109,54 +162,190 @@ func parseInventoryLine(raw string, defUser string, defPort int) (client.HostInf
 		if p, err := strconv.Atoi(portStr); err == nil {
 			info.Port = p
 		} else {
-			return client.HostInfo{}, fmt.Errorf("invalid port in line %q", raw)
+			return client.HostInfo{}, fmt.Errorf("invalid port")
 		}
 	} else {
 		info.Host = unescapeField(line)
 	}
 
+	for _, field := range attrs {
+		key, value, _ := strings.Cut(field, "=")
+		value = unescapeField(value)
+		switch key {
+		case "become":
+			if err := client.ValidBecomeMethod(value); err != nil {
+				return client.HostInfo{}, fmt.Errorf("become attribute: %v", err)
+			}
+			info.BecomeMethod = value
+		case "interpreter":
+			info.Interpreter = value
+		case "os":
+			if err := client.ValidOS(value); err != nil {
+				return client.HostInfo{}, fmt.Errorf("os attribute: %v", err)
+			}
+			info.OS = value
+		default:
+			// secret:NAME=value is an ordinary var whose value is masked
+			// wherever it would otherwise be shown.
+			if name, ok := strings.CutPrefix(key, "secret:"); ok {
+				key = name
+				client.AddSecret(value)
+			}
+			if info.Vars == nil {
+				info.Vars = map[string]string{}
+			}
+			info.Vars[key] = value
+		}
+	}
+
 	return info, nil
 }
 
+// runConfig is what every worker needs to know about the run.
+type runConfig struct {
+	scriptUsed bool
+	copySrc    string
+	copyDest   string
+	copySpec   client.CopySpec
+	fetchSrc   string
+	fetchDir   string
+	fetchSpec  client.FetchSpec
+	fileArg    string
+	scriptArg  string
+	command    string
+	steps      []string
+	stopOnErr  bool
+	opts       client.Options
+	stream     *streamer
+	runDir     *runDir
+	builds     *buildCache
+}
+
 func worker(
 	id int,
 	jobs <-chan client.HostInfo,
 	results chan<- client.Result,
-	scriptUsed bool,
-	fileArg, scriptArg string,
-	timeout time.Duration,
-	allowUnknownHosts bool,
+	cfg *runConfig,
 ) {
 	for host := range jobs {
-		var output string
-		var err error
+		var res client.Result
+		hostOpts := cfg.opts
+
+		var stdout, stderr *lineWriter
+		if cfg.stream != nil {
+			stdout, stderr = cfg.stream.writers(host.Host)
+			hostOpts.Stdout = stdout
+			hostOpts.Stderr = stderr
+		}
 
-		if scriptUsed {
-			output, err = client.RunRemoteScriptWithSudo(
-			host.User,
-			host.Password,
-			strings.TrimSpace(host.SudoPassword),
-			host.Host,
-			host.Port,
-			timeout,
-			scriptArg,)
-		} else {
-			output, err = client.Run(host.User, host.Password, fileArg, host.Host, host.Port, timeout, allowUnknownHosts)
+		var outFile, errFile *os.File
+		if cfg.runDir != nil {
+			var err error
+			outFile, errFile, err = cfg.runDir.hostFiles(host)
+			if err != nil {
+				results <- client.Result{Host: host.Host, User: host.User, Port: host.Port, ExitCode: -1, Error: fmt.Errorf("output file: %w", err)}
+				continue
+			}
+			hostOpts.Stdout = joinWriters(hostOpts.Stdout, outFile)
+			hostOpts.Stderr = joinWriters(hostOpts.Stderr, errFile)
 		}
 
-		results <- client.Result{
-			Host:   host.Host,
-			Output: output,
-			Error:  err,
+		switch {
+		case cfg.copySrc != "":
+			res = client.Copy(host, cfg.copySrc, cfg.copyDest, cfg.copySpec, hostOpts)
+		case cfg.fetchSrc != "":
+			res = client.Fetch(host, cfg.fetchSrc, cfg.fetchDir, cfg.fetchSpec, hostOpts)
+		case cfg.steps != nil:
+			if cfg.command != "" && strings.TrimSpace(host.SudoPassword) != "" {
+				hostOpts.Become = true
+			}
+			res = client.RunSteps(host, cfg.steps, cfg.stopOnErr, hostOpts)
+		case cfg.command != "":
+			// Inline commands escalate whenever the inventory has a sudo
+			// password for the host.
+			if strings.TrimSpace(host.SudoPassword) != "" {
+				hostOpts.Become = true
+			}
+			res = client.RunScript(host, cfg.command, hostOpts)
+		case cfg.builds != nil:
+			// Source scripts are built for the host's platform first.
+			start := time.Now()
+			binary, err := cfg.builds.forHost(host, hostOpts)
+			if err != nil {
+				res = client.FailedResult(host.Host, start, err)
+				break
+			}
+			res = client.RunRemoteScriptWithSudo(host, binary, hostOpts)
+		case cfg.scriptUsed:
+			res = client.RunRemoteScriptWithSudo(host, cfg.scriptArg, hostOpts)
+		default:
+			res = client.Run(host, cfg.fileArg, hostOpts)
 		}
+
+		if cfg.stream != nil {
+			stdout.Flush()
+			stderr.Flush()
+		}
+
+		res.User = host.User
+		res.Port = host.Port
+
+		if cfg.runDir != nil {
+			outFile.Close()
+			errFile.Close()
+			if err := cfg.runDir.finishHost(host, res); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing metadata for %s: %v\n", host.Host, err)
+			}
+		}
+
+		results <- res
 	}
 }
 
+// joinWriters returns w plus f, or just f when w is unset.
+func joinWriters(w io.Writer, f *os.File) io.Writer {
+	if w == nil {
+		return f
+	}
+	return io.MultiWriter(w, f)
+}
+
+// parseSize reads a byte count such as 512, 10K, 200M or 1G (powers of
+// 1024).
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	if num != "" {
+		if i := strings.IndexByte("KMGT", num[len(num)-1]); i >= 0 {
+			mult = 1 << (10 * (i + 1))
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 500K, 20M or 1G)", s)
+	}
+	return n * mult, nil
+}
+
 func main() {
-	var userArg, passwordArg, fileArg, hostArg, scriptArg, inventoryArg string
-	var portArg, timeoutSeconds int
+	var userArg, passwordArg, fileArg, hostArg, scriptArg, commandArg, inventoryArg, outputArg, outputDirArg string
+	var remoteTmpArg, scriptArgsArg, envFileArg, interpreterArg, ccArg string
+	var createsArg, unlessArg, onlyIfArg string
+	var copyFlag, backup, templateFlag, diffFlag bool
+	var fetchFlag, compress bool
+	var maxSizeArg string
+	var modeArg, ownerArg, groupArg string
+	var envArgs []string
+	var keepRemote, checkMode bool
+	var portArg, timeoutSeconds, fanoutArg int
 	var promptForPassword bool
 	var allowUnknownHosts bool
+	var streamOutput, collapseOutput, collapseDiff bool
+	var stepMode, stopOnError bool
+	var become bool
+	var becomeUser, becomeMethod string
+	var reportArgs []string
 
 	pflag.StringVarP(&userArg, "user", "u", "", "SSH username")
 	pflag.StringVarP(&fileArg, "file", "f", "commands.txt", "File containing commands")
@@ -166,18 +355,240 @@ func main() {
 	pflag.IntVarP(&portArg, "port", "p", 22, "SSH port")
 	pflag.BoolVarP(&promptForPassword, "password", "w", false, "Prompt for SSH password")
 	pflag.StringVarP(&scriptArg, "script", "s", "", "Path to a script or binary to upload and execute")
+	pflag.StringVarP(&commandArg, "command", "c", "", "Command to run instead of a commands file (\"-\" reads it from stdin)")
+	pflag.BoolVar(&stepMode, "steps", false, "Run each line (or each ---separated block) as its own step with its own exit status")
+	pflag.BoolVar(&stopOnError, "stop-on-error", false, "With --steps, skip the remaining steps on a host once one fails")
+	pflag.BoolVar(&become, "become", false, "Run commands through the host's become method (sudo unless --become-method or the inventory says otherwise)")
+	pflag.StringVar(&becomeMethod, "become-method", "sudo", "How to gain privileges: "+strings.Join(client.BecomeMethods(), ", ")+"; runas is not supported (inventory become= overrides)")
+	pflag.StringVar(&becomeUser, "become-user", "", "User to become with --become (default root)")
+	pflag.StringVar(&scriptArgsArg, "args", "", "Arguments for the --script, split and quoted like a shell would")
+	pflag.StringArrayVar(&envArgs, "env", nil, "Set KEY=VAL in the --script's environment (repeatable)")
+	pflag.StringVar(&envFileArg, "env-file", "", "Read KEY=VAL lines for the --script's environment from a file")
+	pflag.StringVar(&interpreterArg, "interpreter", "", "Command to run the --script with, e.g. python3 or \"pwsh -File\" (default: chosen by extension)")
+	pflag.StringVar(&ccArg, "cc", "", "C compiler for .c scripts; {os}, {arch} and {target} become each host's platform (e.g. \"zig cc -target {target}\")")
+	pflag.StringVar(&remoteTmpArg, "remote-tmp", "", "Remote directory to create each --script upload's private temp dir in (default $TMPDIR or /tmp, %TEMP% on Windows)")
+	pflag.IntVar(&fanoutArg, "fanout", 0, "Send the --script or copy files to N hosts, and have every host that has them pass them on to N more (forwards your ssh-agent to those hosts)")
+	pflag.BoolVar(&keepRemote, "keep-remote", false, "Leave the uploaded --script and its temp dir on the remote host")
+	pflag.StringVar(&createsArg, "creates", "", "Skip a host when this remote path already exists")
+	pflag.StringVar(&unlessArg, "unless", "", "Skip a host when this remote command exits 0")
+	pflag.StringVar(&onlyIfArg, "only-if", "", "Skip a host unless this remote command exits 0")
+	pflag.BoolVar(&checkMode, "check", false, "Dry run: connect to every host and show what would be uploaded and run, without changing anything or running guard commands")
 	pflag.BoolVarP(&allowUnknownHosts, "allow-unknown-hosts", "a", false, "Allow connecting to unknown SSH hosts")
+	pflag.StringVarP(&outputArg, "output", "o", "text", "Output format: text, json, ndjson or yaml")
+	pflag.BoolVar(&streamOutput, "stream", false, "Print output lines as they arrive, prefixed with the host")
+	pflag.BoolVar(&collapseOutput, "collapse", false, "Print hosts with identical output once, under a compressed host list")
+	pflag.StringVar(&outputDirArg, "output-dir", "", "Save each host's stdout, stderr and metadata under DIR/<run-id>/")
+	pflag.StringArrayVar(&reportArgs, "report", nil, "Write a run report, as junit=PATH or html=PATH (repeatable)")
+	pflag.BoolVar(&collapseDiff, "collapse-diff", false, "With --collapse, show outlier hosts as a diff against the majority output")
+
+	pflag.BoolVar(&copyFlag, "copy", false, "Copy SRC to DEST on every host instead of running anything (same as godev copy SRC DEST)")
+	pflag.StringVar(&modeArg, "mode", "", "With copy, octal mode for every copied file, e.g. 0644")
+	pflag.StringVar(&ownerArg, "owner", "", "With copy, owner for every copied file and directory (implies --become)")
+	pflag.StringVar(&groupArg, "group", "", "With copy, group for every copied file and directory (implies --become)")
+	pflag.BoolVar(&backup, "backup", false, "With copy, keep each replaced file as FILE.<timestamp>.bak")
+	pflag.BoolVar(&templateFlag, "template", false, "With copy, render each file as a Go text/template for every host before uploading it")
+	pflag.BoolVar(&diffFlag, "diff", false, "With copy, show a unified diff of every file about to change")
+	pflag.BoolVar(&fetchFlag, "fetch", false, "Fetch REMOTE from every host into DIR/<host>/ (same as godev fetch REMOTE DIR)")
+	pflag.BoolVar(&compress, "compress", false, "With fetch, gzip files on the host before sending them")
+	pflag.StringVar(&maxSizeArg, "max-size", "", "With fetch, fail a host with more than this much to fetch, e.g. 100M")
 
 	pflag.Parse()
 
+	// godev copy SRC DEST is the same as godev --copy SRC DEST, and godev
+	// fetch REMOTE DIR the same as godev --fetch REMOTE DIR. godev shell
+	// has no flag form.
+	args := pflag.Args()
+	shellUsed := false
+	if len(args) > 0 && args[0] == "copy" {
+		args = args[1:]
+		copyFlag = true
+	} else if len(args) > 0 && args[0] == "fetch" {
+		args = args[1:]
+		fetchFlag = true
+	} else if len(args) > 0 && args[0] == "shell" {
+		args = args[1:]
+		shellUsed = true
+	}
+
 	fileUsed := pflag.Lookup("file").Changed
 	scriptUsed := pflag.Lookup("script").Changed
+	commandUsed := pflag.Lookup("command").Changed
+	copyUsed := copyFlag
+	fetchUsed := fetchFlag
 
-	if !fileUsed && !scriptUsed {
-		fmt.Fprintln(os.Stderr, "Error: Either --file or --script must be provided.")
+	if !fileUsed && !scriptUsed && !commandUsed && !copyUsed && !fetchUsed && !shellUsed {
+		fmt.Fprintln(os.Stderr, "Error: One of --file, --script, --command, copy, fetch or shell must be provided.")
 		pflag.Usage()
 		os.Exit(1)
 	}
+	if shellUsed {
+		if fileUsed || scriptUsed || commandUsed || copyUsed || fetchUsed || len(args) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: shell takes no arguments and cannot be combined with --file, --script, --command, copy or fetch.")
+			os.Exit(1)
+		}
+		if become || becomeUser != "" || pflag.Lookup("become-method").Changed {
+			fmt.Fprintln(os.Stderr, "Error: shell runs commands as the SSH user and cannot be combined with --become.")
+			os.Exit(1)
+		}
+		if stepMode || stopOnError || checkMode || createsArg != "" || unlessArg != "" || onlyIfArg != "" ||
+			outputArg != "text" || streamOutput || collapseOutput || collapseDiff || outputDirArg != "" || len(reportArgs) > 0 {
+			fmt.Fprintln(os.Stderr, "Error: shell cannot be combined with --steps, --check, guards or output options.")
+			os.Exit(1)
+		}
+	}
+
+	var copySrc, copyDest string
+	if copyUsed {
+		if fileUsed || scriptUsed || commandUsed {
+			fmt.Fprintln(os.Stderr, "Error: copy cannot be combined with --file, --script or --command.")
+			os.Exit(1)
+		}
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Error: copy needs a SRC and a DEST.")
+			os.Exit(1)
+		}
+		copySrc, copyDest = args[0], args[1]
+		if _, err := os.Stat(copySrc); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	var fetchSrc, fetchDir string
+	if fetchUsed {
+		if fileUsed || scriptUsed || commandUsed || copyUsed {
+			fmt.Fprintln(os.Stderr, "Error: fetch cannot be combined with --file, --script, --command or copy.")
+			os.Exit(1)
+		}
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "Error: fetch needs a REMOTE path and a local DIR.")
+			os.Exit(1)
+		}
+		fetchSrc, fetchDir = args[0], args[1]
+		if become || becomeUser != "" || pflag.Lookup("become-method").Changed {
+			fmt.Fprintln(os.Stderr, "Error: fetch reads files as the SSH user and cannot be combined with --become.")
+			os.Exit(1)
+		}
+	}
+	if (compress || maxSizeArg != "") && !fetchUsed {
+		fmt.Fprintln(os.Stderr, "Error: --compress and --max-size can only be used with fetch.")
+		os.Exit(1)
+	}
+	var maxSize int64
+	if maxSizeArg != "" {
+		var err error
+		if maxSize, err = parseSize(maxSizeArg); err != nil {
+			fmt.Fprintln(os.Stderr, "Error: --max-size:", err)
+			os.Exit(1)
+		}
+	}
+	if (modeArg != "" || ownerArg != "" || groupArg != "" || backup || templateFlag || diffFlag) && !copyUsed {
+		fmt.Fprintln(os.Stderr, "Error: --mode, --owner, --group, --backup, --template and --diff can only be used with copy.")
+		os.Exit(1)
+	}
+	if templateFlag {
+		if err := client.CheckTemplates(copySrc); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in template:", err)
+			os.Exit(1)
+		}
+	}
+	if modeArg != "" {
+		if m, err := strconv.ParseUint(modeArg, 8, 32); err != nil || m > 0o7777 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --mode %q (want an octal mode such as 0644)\n", modeArg)
+			os.Exit(1)
+		}
+	}
+	if ownerArg != "" || groupArg != "" {
+		become = true
+	}
+
+	if commandUsed && (fileUsed || scriptUsed) {
+		fmt.Fprintln(os.Stderr, "Error: --command cannot be combined with --file or --script.")
+		os.Exit(1)
+	}
+
+	if commandArg == "-" {
+		// Both would read the terminal: the command would take what was
+		// meant as the password, or the password prompt would find stdin
+		// already used up.
+		if promptForPassword {
+			fmt.Fprintln(os.Stderr, "Error: --command - reads stdin and cannot be combined with -w/--password.")
+			os.Exit(1)
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading command from stdin:", err)
+			os.Exit(1)
+		}
+		commandArg = string(data)
+	}
+	if commandUsed && strings.TrimSpace(commandArg) == "" {
+		fmt.Fprintln(os.Stderr, "Error: --command is empty.")
+		os.Exit(1)
+	}
+
+	if stopOnError {
+		stepMode = true
+	}
+	if becomeUser != "" || pflag.Lookup("become-method").Changed {
+		become = true
+	}
+	if err := client.ValidBecomeMethod(becomeMethod); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if (remoteTmpArg != "" || keepRemote) && !scriptUsed && !copyUsed {
+		fmt.Fprintln(os.Stderr, "Error: --remote-tmp and --keep-remote can only be used with --script or copy.")
+		os.Exit(1)
+	}
+	if (scriptArgsArg != "" || len(envArgs) > 0 || envFileArg != "" || interpreterArg != "" || ccArg != "") && !scriptUsed {
+		fmt.Fprintln(os.Stderr, "Error: --args, --env, --env-file, --interpreter and --cc can only be used with --script.")
+		os.Exit(1)
+	}
+	scriptArgs, err := splitShellWords(scriptArgsArg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: --args:", err)
+		os.Exit(1)
+	}
+	var scriptEnv []string
+	if envFileArg != "" {
+		scriptEnv, err = readEnvFile(envFileArg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading env file:", err)
+			os.Exit(1)
+		}
+	}
+	for _, kv := range envArgs {
+		kv, err := parseEnv(kv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		scriptEnv = append(scriptEnv, kv)
+	}
+	if (createsArg != "" || unlessArg != "" || onlyIfArg != "") && (copyUsed || fetchUsed) {
+		fmt.Fprintln(os.Stderr, "Error: --creates, --unless and --only-if guard commands and scripts, not copy or fetch.")
+		os.Exit(1)
+	}
+	if fanoutArg < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --fanout cannot be negative.")
+		os.Exit(1)
+	}
+	if fanoutArg > 0 && !scriptUsed && !copyUsed {
+		fmt.Fprintln(os.Stderr, "Error: --fanout can only be used with --script or copy.")
+		os.Exit(1)
+	}
+	if fanoutArg > 0 && templateFlag {
+		fmt.Fprintln(os.Stderr, "Error: --fanout cannot be combined with --template, which renders every host's files differently.")
+		os.Exit(1)
+	}
+	if fanoutArg > 0 && os.Getenv("SSH_AUTH_SOCK") == "" {
+		fmt.Fprintln(os.Stderr, "[WARN] --fanout needs a running ssh-agent (SSH_AUTH_SOCK) to forward; uploading to every host directly.")
+		fanoutArg = 0
+	}
+	if stepMode && (scriptUsed || copyUsed || fetchUsed) {
+		fmt.Fprintln(os.Stderr, "Error: --steps can only be used with --file or --command.")
+		os.Exit(1)
+	}
 
 	if fileUsed && filepath.Ext(fileArg) != ".txt" {
 		fmt.Fprintln(os.Stderr, "Error: Only .txt files are allowed with the --file option.")
@@ -189,6 +600,37 @@ func main() {
 		os.Exit(1)
 	}
 
+	printer, err := newPrinter(outputArg, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if streamOutput && outputArg != "text" {
+		fmt.Fprintln(os.Stderr, "Error: --stream can only be used with --output text.")
+		os.Exit(1)
+	}
+
+	if collapseDiff {
+		collapseOutput = true
+	}
+	if collapseOutput && (streamOutput || outputArg != "text") {
+		fmt.Fprintln(os.Stderr, "Error: --collapse can only be used with --output text and without --stream.")
+		os.Exit(1)
+	}
+	printer.collapse = collapseOutput
+	printer.collapseDiff = collapseDiff
+
+	var reports []reportSpec
+	for _, arg := range reportArgs {
+		spec, err := parseReportSpec(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		reports = append(reports, spec)
+	}
+
 	if portArg < 1 || portArg > 65535 {
 		fmt.Fprintln(os.Stderr, "Error: Port must be between 1 and 65535.")
 		os.Exit(1)
@@ -215,6 +657,7 @@ func main() {
 		}
 		passwordArg = string(p)
 	}
+	client.AddSecret(passwordArg)
 
 	if passwordArg == "" {
 		found := false
@@ -279,10 +722,12 @@ func main() {
 		defer f.Close()
 
 		scanner := bufio.NewScanner(f)
+		lineNo := 0
 		for scanner.Scan() {
+			lineNo++
 			h, err := parseInventoryLine(scanner.Text(), userArg, portArg)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Skipping invalid line: %s\n", err)
+				fmt.Fprintf(os.Stderr, "Skipping invalid line %d of %s: %s\n", lineNo, inventoryArg, client.Redact(err.Error()))
 				continue
 			}
 			if h.Host != "" {
@@ -296,17 +741,167 @@ func main() {
 		}
 	}
 
+	client.NameHosts(hosts)
+
 	if len(hosts) == 0 {
 		fmt.Fprintln(os.Stderr, "Error: No valid hosts found in inventory file or supplied with the -host option.")
 		os.Exit(1)
 	}
 
+	if checkMode {
+		names := make([]string, len(hosts))
+		for i, h := range hosts {
+			names[i] = net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+		}
+		fmt.Fprintf(os.Stderr, "Check mode: nothing will be changed. Would contact %d host(s): %s\n", len(hosts), strings.Join(names, ", "))
+	}
+
+	if shellUsed {
+		runShell(hosts, client.Options{
+			Timeout:           timeout,
+			AllowUnknownHosts: allowUnknownHosts,
+		})
+		return
+	}
+
 	jobs := make(chan client.HostInfo, len(hosts))
 	results := make(chan client.Result, len(hosts))
 
+	cfg := &runConfig{
+		scriptUsed: scriptUsed,
+		copySrc:    copySrc,
+		copyDest:   copyDest,
+		copySpec: client.CopySpec{
+			Mode:     modeArg,
+			Owner:    ownerArg,
+			Group:    groupArg,
+			Backup:   backup,
+			Template: templateFlag,
+			Diff:     diffFlag,
+		},
+		fetchSrc: fetchSrc,
+		fetchDir: fetchDir,
+		fetchSpec: client.FetchSpec{
+			Compress: compress,
+			MaxSize:  maxSize,
+		},
+		fileArg:    fileArg,
+		scriptArg:  scriptArg,
+		command:    commandArg,
+		stopOnErr:  stopOnError,
+		opts: client.Options{
+			Timeout:           timeout,
+			AllowUnknownHosts: allowUnknownHosts,
+			Become:            become,
+			BecomeMethod:      becomeMethod,
+			BecomeUser:        becomeUser,
+			RemoteTmp:         remoteTmpArg,
+			KeepRemote:        keepRemote,
+			Args:              scriptArgs,
+			Env:               scriptEnv,
+			Interpreter:       interpreterArg,
+			Check:             checkMode,
+			Creates:           createsArg,
+			Unless:            unlessArg,
+			OnlyIf:            onlyIfArg,
+			FromCache:         fanoutArg > 0,
+		},
+	}
+
+	if scriptUsed && buildLang(scriptArg) != "" {
+		builds, err := newBuildCache(scriptArg, ccArg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error preparing build:", err)
+			os.Exit(1)
+		}
+		cfg.builds = builds
+	}
+
+	if stepMode {
+		content := commandArg
+		if !commandUsed {
+			data, err := os.ReadFile(fileArg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error reading commands file:", err)
+				os.Exit(1)
+			}
+			content = string(data)
+		}
+		cfg.steps = client.SplitSteps(content)
+		if len(cfg.steps) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: No steps found.")
+			os.Exit(1)
+		}
+	}
+
+	if streamOutput {
+		names := make([]string, len(hosts))
+		for i, h := range hosts {
+			names[i] = h.Host
+		}
+		cfg.stream = newStreamer(names)
+		printer.stream = cfg.stream
+	}
+
+	if outputDirArg != "" {
+		manifest := runManifest{
+			SSHUser:   userArg,
+			Hosts:     len(hosts),
+			StartedAt: time.Now(),
+		}
+		if u, err := user.Current(); err == nil {
+			manifest.LocalUser = u.Username
+		}
+		switch {
+		case commandUsed:
+			manifest.CommandFile = "(--command)"
+			manifest.CommandSHA256 = stringSHA256(commandArg)
+		case copyUsed:
+			manifest.CommandFile = "(copy " + copySrc + " " + copyDest + ")"
+			manifest.CommandSHA256 = stringSHA256(manifest.CommandFile)
+		case fetchUsed:
+			manifest.CommandFile = "(fetch " + fetchSrc + " " + fetchDir + ")"
+			manifest.CommandSHA256 = stringSHA256(manifest.CommandFile)
+		case scriptUsed:
+			manifest.CommandFile = scriptArg
+		default:
+			manifest.CommandFile = fileArg
+		}
+		if !commandUsed && !copyUsed && !fetchUsed {
+			if manifest.CommandSHA256, err = fileSHA256(manifest.CommandFile); err != nil {
+				fmt.Fprintln(os.Stderr, "Error hashing command file:", err)
+				os.Exit(1)
+			}
+		}
+		if hostArg == "" {
+			manifest.Inventory = inventoryArg
+			if manifest.InventorySHA, err = fileSHA256(inventoryArg); err != nil {
+				fmt.Fprintln(os.Stderr, "Error hashing inventory:", err)
+				os.Exit(1)
+			}
+		}
+		if cfg.runDir, err = newRunDir(outputDirArg, manifest); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		printer.runDir = cfg.runDir
+
+		// Plain text output only needs each host's status once the output
+		// is on disk, so keep it out of memory.
+		if outputArg == "text" && !collapseOutput && len(reports) == 0 {
+			cfg.opts.SkipCapture = true
+		}
+	}
+
+	runStart := time.Now()
+
+	if fanoutArg > 0 && !checkMode {
+		distribute(hosts, cfg, fanoutArg)
+	}
+
 	// Start workers
 	for i := 0; i < workerCount; i++ {
-		go worker(i, jobs, results, scriptUsed, fileArg, scriptArg, timeout, allowUnknownHosts)
+		go worker(i, jobs, results, cfg)
 	}
 
 	// Send jobs
@@ -316,15 +911,49 @@ func main() {
 	close(jobs)
 
 	// Collect results
+	var all []client.Result
 	for i := 0; i < len(hosts); i++ {
 		res := <-results
-		fmt.Printf("======================================\n")
-		if res.Error != nil {
-			fmt.Printf("------ Error with host %s -----\n", res.Host)
-			fmt.Printf("======================================\n\n%v\n", res.Error)
-		} else {
-			fmt.Printf("----- Output from host %s -----\n", res.Host)
-			fmt.Printf("======================================\n\n%s\n", res.Output)
+		if len(reports) > 0 {
+			all = append(all, res)
+		}
+		if err := printer.add(res); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing output:", err)
+		}
+	}
+	if err := printer.flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing output:", err)
+		os.Exit(1)
+	}
+
+	run := reportRun{
+		Name:      filepath.Base(fileArg),
+		StartedAt: runStart,
+		Duration:  time.Since(runStart).Round(time.Millisecond),
+	}
+	if scriptUsed {
+		run.Name = filepath.Base(scriptArg)
+	}
+	if commandUsed {
+		run.Name = "command"
+	}
+	if copyUsed {
+		run.Name = "copy"
+	}
+	if fetchUsed {
+		run.Name = "fetch"
+	}
+	for _, spec := range reports {
+		if err := writeReport(spec, run, all); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s report: %v\n", spec.kind, err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.runDir != nil {
+		if err := cfg.runDir.finish(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing run manifest:", err)
+			os.Exit(1)
 		}
 	}
 }
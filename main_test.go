@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godev/client"
+)
+
+func TestParseInventoryLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want client.HostInfo
+	}{
+		{"", client.HostInfo{}},
+		{"  # just a comment", client.HostInfo{}},
+		{"web1", client.HostInfo{User: "me", Host: "web1", Port: 22}},
+		{"root@web1:2222", client.HostInfo{User: "root", Host: "web1", Port: 2222}},
+		{"web1::pass:::sudo", client.HostInfo{User: "me", Host: "web1", Port: 22, Password: "pass", SudoPassword: "sudo"}},
+		{"web1:::p\\#ss # comment", client.HostInfo{User: "me", Host: "web1", Port: 22, SudoPassword: "p#ss"}},
+		// Spaces in passwords are kept, as they always were.
+		{"web1::my pass:::sudo pw", client.HostInfo{User: "me", Host: "web1", Port: 22, Password: "my pass", SudoPassword: "sudo pw"}},
+		{"web1:::sudo pw become=su role=db", client.HostInfo{
+			User: "me", Host: "web1", Port: 22, SudoPassword: "sudo pw", BecomeMethod: "su",
+			Vars: map[string]string{"role": "db"},
+		}},
+		{"web1 os=windows interpreter=pwsh\\ -File", client.HostInfo{
+			User: "me", Host: "web1", Port: 22, OS: "windows", Interpreter: "pwsh -File",
+		}},
+		{"web1 secret:token=abc", client.HostInfo{User: "me", Host: "web1", Port: 22, Vars: map[string]string{"token": "abc"}}},
+		// Only name=value fields are attributes.
+		{"web1:::pw a=b=c x", client.HostInfo{User: "me", Host: "web1", Port: 22, SudoPassword: "pw a=b=c x"}},
+	}
+	for _, tt := range tests {
+		got, err := parseInventoryLine(tt.line, "me", 22)
+		if err != nil {
+			t.Errorf("parseInventoryLine(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseInventoryLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseInventoryLineErrorsHideLine(t *testing.T) {
+	for _, line := range []string{
+		"web1:x2::hunter2:::hunter3",
+		"web1:::hunter3 become=nope",
+		"web1:::hunter3 os=plan9",
+	} {
+		_, err := parseInventoryLine(line, "me", 22)
+		if err == nil {
+			t.Errorf("parseInventoryLine(%q): no error", line)
+			continue
+		}
+		if strings.Contains(err.Error(), "hunter") {
+			t.Errorf("parseInventoryLine(%q) error %q shows a password", line, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"10K", 10 << 10},
+		{"10kb", 10 << 10},
+		{" 200M ", 200 << 20},
+		{"1G", 1 << 30},
+		{"2T", 2 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "K", "0", "-1M", "1.5G", "10X", "B"} {
+		if got, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestGroupResults(t *testing.T) {
+	failed := errors.New("command failed")
+	results := []client.Result{
+		{Host: "web1", Stdout: "done\n"},
+		{Host: "web2", Stdout: "done\n", ExitCode: 1, Error: failed},
+		{Host: "web3", Stdout: "done\n"},
+		{Host: "web4", Stdout: "done\n", ExitCode: 2, Error: failed},
+		{Host: "web5", Stdout: "done\n", Signal: "KILL", Error: failed},
+		{Host: "web6", Stdout: "done\n", Stderr: "warning\n"},
+	}
+	var got [][]string
+	for _, g := range groupResults(results) {
+		got = append(got, g.hosts)
+	}
+	// Identical output with a different exit status is a different group,
+	// and the largest group comes first.
+	want := [][]string{{"web1", "web3"}, {"web2"}, {"web4"}, {"web5"}, {"web6"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupResults hosts = %v, want %v", got, want)
+	}
+}
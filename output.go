@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"godev/client"
+)
+
+var outputFormats = []string{"text", "json", "ndjson", "yaml"}
+
+// outputRecord is the machine-readable form of a client.Result.
+type outputRecord struct {
+	Host       string       `json:"host"`
+	User       string       `json:"user"`
+	Port       int          `json:"port"`
+	ExitCode   int          `json:"exit_code"`
+	Signal     string       `json:"signal,omitempty"`
+	Status     string       `json:"status,omitempty"`
+	Stdout     string       `json:"stdout"`
+	Stderr     string       `json:"stderr"`
+	Error      string       `json:"error,omitempty"`
+	ErrorClass string       `json:"error_class,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	DurationMS int64        `json:"duration_ms"`
+	FailedStep int          `json:"failed_step,omitempty"`
+	Steps      []stepRecord `json:"steps,omitempty"`
+}
+
+// stepRecord is the machine-readable form of a client.StepResult.
+type stepRecord struct {
+	Step       int       `json:"step"`
+	Command    string    `json:"command"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Signal     string    `json:"signal,omitempty"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// stepStatus is "ok", "failed" or "skipped".
+func stepStatus(st client.StepResult) string {
+	switch {
+	case st.Skipped:
+		return "skipped"
+	case st.Error != nil:
+		return "failed"
+	}
+	return "ok"
+}
+
+func newOutputRecord(res client.Result) outputRecord {
+	rec := outputRecord{
+		Host:       res.Host,
+		User:       res.User,
+		Port:       res.Port,
+		ExitCode:   res.ExitCode,
+		Signal:     res.Signal,
+		Status:     res.Status,
+		Stdout:     res.Stdout,
+		Stderr:     res.Stderr,
+		ErrorClass: res.ErrorClass(),
+		StartedAt:  res.StartedAt,
+		DurationMS: res.Duration.Milliseconds(),
+	}
+	if res.Error != nil {
+		rec.Error = res.Error.Error()
+	}
+	rec.FailedStep = res.FailedStep
+	for i, st := range res.Steps {
+		sr := stepRecord{
+			Step:       i + 1,
+			Command:    st.Command,
+			Status:     stepStatus(st),
+			ExitCode:   st.ExitCode,
+			Signal:     st.Signal,
+			Stdout:     st.Stdout,
+			Stderr:     st.Stderr,
+			StartedAt:  st.StartedAt,
+			DurationMS: st.Duration.Milliseconds(),
+		}
+		if st.Error != nil {
+			sr.Error = st.Error.Error()
+		}
+		rec.Steps = append(rec.Steps, sr)
+	}
+	return rec
+}
+
+// printer writes results in the format chosen with --output. Text and
+// NDJSON are written as each host finishes; JSON and YAML need the whole
+// run and are written by flush. When stream is set the output has already
+// been printed line by line, so text mode only reports each host's status.
+// With collapse, text output is held back until flush so hosts with
+// identical output can be printed together. With runDir and no stream,
+// text mode reports each host's status and where its output was saved.
+type printer struct {
+	format       string
+	w            io.Writer
+	stream       *streamer
+	runDir       *runDir
+	collapse     bool
+	collapseDiff bool
+	results      []client.Result
+	records      []outputRecord
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	for _, f := range outputFormats {
+		if f == format {
+			return &printer{format: format, w: w}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown output format %q (want one of %v)", format, outputFormats)
+}
+
+func (p *printer) add(res client.Result) error {
+	switch p.format {
+	case "text":
+		if p.stream != nil {
+			p.stream.printf("%s\n", statusLine(res))
+			return nil
+		}
+		if p.collapse {
+			p.results = append(p.results, res)
+			return nil
+		}
+		if p.runDir != nil {
+			fmt.Fprintf(p.w, "%s (output in %s)\n", statusLine(res), p.runDir.path)
+			return nil
+		}
+		printText(p.w, res)
+		return nil
+	case "ndjson":
+		return json.NewEncoder(p.w).Encode(newOutputRecord(res))
+	default:
+		p.records = append(p.records, newOutputRecord(res))
+		return nil
+	}
+}
+
+func (p *printer) flush() error {
+	switch p.format {
+	case "text":
+		if p.collapse {
+			printCollapsed(p.w, p.results, p.collapseDiff)
+		}
+	case "json":
+		if p.records == nil {
+			p.records = []outputRecord{}
+		}
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p.records)
+	case "yaml":
+		return writeYAML(p.w, p.records)
+	}
+	return nil
+}
+
+func printText(w io.Writer, res client.Result) {
+	fmt.Fprintf(w, "======================================\n")
+	if res.Error != nil {
+		fmt.Fprintf(w, "------ Error with host %s -----\n", res.Host)
+		fmt.Fprintf(w, "======================================\n\n")
+		printSteps(w, res.Steps)
+		fmt.Fprintf(w, "%v\n", res.Error)
+		if res.Stdout != "" {
+			fmt.Fprintf(w, "stdout:\n%s\n", res.Stdout)
+		}
+		if res.Stderr != "" {
+			fmt.Fprintf(w, "stderr:\n%s\n", res.Stderr)
+		}
+	} else if res.Status == "skipped" {
+		fmt.Fprintf(w, "----- Skipped host %s -----\n", res.Host)
+		fmt.Fprintf(w, "======================================\n\n")
+		fmt.Fprintf(w, "%s\n", res.Stdout)
+	} else {
+		fmt.Fprintf(w, "----- Output from host %s -----\n", res.Host)
+		fmt.Fprintf(w, "======================================\n\n")
+		printSteps(w, res.Steps)
+		fmt.Fprintf(w, "%s\n", res.Stdout)
+		if res.Stderr != "" {
+			fmt.Fprintf(w, "%s\n", res.Stderr)
+		}
+	}
+}
+
+// printSteps lists how each step of a --steps run went.
+func printSteps(w io.Writer, steps []client.StepResult) {
+	if len(steps) == 0 {
+		return
+	}
+	for i, st := range steps {
+		status := stepStatus(st)
+		if st.Skipped {
+			fmt.Fprintf(w, "step %d/%d %s: %s\n", i+1, len(steps), status, client.StepLabel(st.Command))
+			continue
+		}
+		fmt.Fprintf(w, "step %d/%d %s (exit %d, %s): %s\n", i+1, len(steps), status,
+			st.ExitCode, st.Duration.Round(time.Millisecond), client.StepLabel(st.Command))
+	}
+	fmt.Fprintln(w)
+}
+
+// statusLine summarises how a host finished, for use after its output has
+// been streamed.
+func statusLine(res client.Result) string {
+	took := res.Duration.Round(time.Millisecond)
+	if res.Error != nil {
+		return fmt.Sprintf("%s: failed after %s: %v", res.Host, took, res.Error)
+	}
+	if res.Status != "" {
+		return fmt.Sprintf("%s: %s in %s", res.Host, res.Status, took)
+	}
+	return fmt.Sprintf("%s: ok (exit %d) in %s", res.Host, res.ExitCode, took)
+}
+
+// writeYAML emits records as a YAML sequence of mappings. Strings are
+// written double-quoted so arbitrary command output stays valid YAML
+// without pulling in a YAML library.
+func writeYAML(w io.Writer, records []outputRecord) error {
+	if len(records) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	for _, r := range records {
+		fields := []yamlField{
+			{"host", strconv.Quote(r.Host)},
+			{"user", strconv.Quote(r.User)},
+			{"port", strconv.Itoa(r.Port)},
+			{"exit_code", strconv.Itoa(r.ExitCode)},
+			{"signal", strconv.Quote(r.Signal)},
+			{"stdout", strconv.Quote(r.Stdout)},
+			{"stderr", strconv.Quote(r.Stderr)},
+			{"error", strconv.Quote(r.Error)},
+			{"error_class", strconv.Quote(r.ErrorClass)},
+			{"started_at", strconv.Quote(r.StartedAt.Format(time.RFC3339Nano))},
+			{"duration_ms", strconv.FormatInt(r.DurationMS, 10)},
+		}
+		if r.Status != "" {
+			fields = append(fields, yamlField{"status", strconv.Quote(r.Status)})
+		}
+		if r.FailedStep > 0 {
+			fields = append(fields, yamlField{"failed_step", strconv.Itoa(r.FailedStep)})
+		}
+		if err := writeYAMLMapping(w, "", fields); err != nil {
+			return err
+		}
+		if len(r.Steps) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, "  steps:"); err != nil {
+			return err
+		}
+		for _, st := range r.Steps {
+			err := writeYAMLMapping(w, "    ", []yamlField{
+				{"step", strconv.Itoa(st.Step)},
+				{"command", strconv.Quote(st.Command)},
+				{"status", strconv.Quote(st.Status)},
+				{"exit_code", strconv.Itoa(st.ExitCode)},
+				{"signal", strconv.Quote(st.Signal)},
+				{"stdout", strconv.Quote(st.Stdout)},
+				{"stderr", strconv.Quote(st.Stderr)},
+				{"error", strconv.Quote(st.Error)},
+				{"started_at", strconv.Quote(st.StartedAt.Format(time.RFC3339Nano))},
+				{"duration_ms", strconv.FormatInt(st.DurationMS, 10)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type yamlField struct {
+	key   string
+	value string
+}
+
+// writeYAMLMapping writes fields as one item of a YAML sequence, indented
+// by indent.
+func writeYAMLMapping(w io.Writer, indent string, fields []yamlField) error {
+	for i, f := range fields {
+		prefix := indent + "  "
+		if i == 0 {
+			prefix = indent + "- "
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", prefix, f.key, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"godev/client"
+)
+
+// goldenResults are one host that worked, printing a secret the client
+// masked, and one that could not be reached.
+func goldenResults() []client.Result {
+	client.AddSecret("s3cr3t-token")
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []client.Result{
+		{
+			Host: "web1", User: "deploy", Port: 22,
+			Stdout:    client.Redact("token=s3cr3t-token\n"),
+			StartedAt: start, Duration: 1500 * time.Millisecond,
+		},
+		{
+			Host: "web2", User: "deploy", Port: 2222,
+			Error:     &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			StartedAt: start, Duration: 20 * time.Millisecond,
+		},
+	}
+}
+
+// The field names are a public contract: scripts and dashboards read
+// exit_code, error_class, duration_ms and started_at.
+func TestOutputGolden(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"json", `[
+  {
+    "host": "web1",
+    "user": "deploy",
+    "port": 22,
+    "exit_code": 0,
+    "stdout": "token=********\n",
+    "stderr": "",
+    "started_at": "2026-01-02T03:04:05Z",
+    "duration_ms": 1500
+  },
+  {
+    "host": "web2",
+    "user": "deploy",
+    "port": 2222,
+    "exit_code": 0,
+    "stdout": "",
+    "stderr": "",
+    "error": "dial tcp: connection refused",
+    "error_class": "connect",
+    "started_at": "2026-01-02T03:04:05Z",
+    "duration_ms": 20
+  }
+]
+`},
+		{"ndjson", `{"host":"web1","user":"deploy","port":22,"exit_code":0,"stdout":"token=********\n","stderr":"","started_at":"2026-01-02T03:04:05Z","duration_ms":1500}
+{"host":"web2","user":"deploy","port":2222,"exit_code":0,"stdout":"","stderr":"","error":"dial tcp: connection refused","error_class":"connect","started_at":"2026-01-02T03:04:05Z","duration_ms":20}
+`},
+		{"yaml", `- host: "web1"
+  user: "deploy"
+  port: 22
+  exit_code: 0
+  signal: ""
+  stdout: "token=********\n"
+  stderr: ""
+  error: ""
+  error_class: ""
+  started_at: "2026-01-02T03:04:05Z"
+  duration_ms: 1500
+- host: "web2"
+  user: "deploy"
+  port: 2222
+  exit_code: 0
+  signal: ""
+  stdout: ""
+  stderr: ""
+  error: "dial tcp: connection refused"
+  error_class: "connect"
+  started_at: "2026-01-02T03:04:05Z"
+  duration_ms: 20
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		p, err := newPrinter(tt.format, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range goldenResults() {
+			if err := p.add(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := p.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s output:\n%s\nwant:\n%s", tt.format, got, tt.want)
+		}
+		if strings.Contains(buf.String(), "s3cr3t-token") {
+			t.Errorf("%s output shows the secret", tt.format)
+		}
+	}
+}
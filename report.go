@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"godev/client"
+)
+
+// reportSpec is one --report KIND=PATH argument.
+type reportSpec struct {
+	kind string
+	path string
+}
+
+var reportKinds = []string{"junit", "html"}
+
+func parseReportSpec(arg string) (reportSpec, error) {
+	kind, path, ok := strings.Cut(arg, "=")
+	if !ok || path == "" {
+		return reportSpec{}, fmt.Errorf("invalid report %q (want KIND=PATH)", arg)
+	}
+	for _, k := range reportKinds {
+		if k == kind {
+			return reportSpec{kind: kind, path: path}, nil
+		}
+	}
+	return reportSpec{}, fmt.Errorf("unknown report kind %q (want one of %v)", kind, reportKinds)
+}
+
+// reportRun is what the report writers know about the run as a whole.
+type reportRun struct {
+	Name      string
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+func writeReport(spec reportSpec, run reportRun, results []client.Result) error {
+	f, err := os.OpenFile(spec.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	switch spec.kind {
+	case "junit":
+		err = writeJUnit(f, run, results)
+	case "html":
+		err = writeHTML(f, run, results)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeJUnit writes one testsuite with a testcase per host. Hosts whose
+// command ran and failed are failures; hosts where it could not run at all
+// are errors. Either carries the host's stderr. Hosts a guard kept from
+// running are skipped.
+func writeJUnit(f *os.File, run reportRun, results []client.Result) error {
+	suite := junitSuite{
+		Name:      run.Name,
+		Tests:     len(results),
+		Time:      seconds(run.Duration),
+		Timestamp: run.StartedAt.UTC().Format(time.RFC3339),
+	}
+	for _, res := range sortedResults(results) {
+		tc := junitCase{
+			ClassName: "godev." + run.Name,
+			Name:      res.Host,
+			Time:      seconds(res.Duration),
+			SystemOut: res.Stdout,
+			SystemErr: res.Stderr,
+		}
+		if res.Error == nil && res.Status == "skipped" {
+			tc.Skipped = &junitSkipped{Message: strings.TrimSpace(res.Stdout)}
+			suite.Skipped++
+		}
+		if res.Error != nil {
+			problem := &junitProblem{
+				Message: res.Error.Error(),
+				Type:    res.ErrorClass(),
+				Body:    res.Stderr,
+			}
+			if class := res.ErrorClass(); class == "exit" || class == "signal" {
+				tc.Failure = problem
+				suite.Failures++
+			} else {
+				tc.Error = problem
+				suite.Errors++
+			}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := f.WriteString("\n")
+	return err
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>godev report: {{.Run.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; cursor: pointer; user-select: none; }
+tr.failed td.status { color: #b00; font-weight: bold; }
+tr.ok td.status { color: #080; }
+tr.skipped td.status { color: #888; }
+pre { margin: 4px 0; white-space: pre-wrap; max-height: 40em; overflow: auto; }
+</style>
+</head>
+<body>
+<h1>godev report: {{.Run.Name}}</h1>
+<p>Started {{.Run.StartedAt.Format "2006-01-02 15:04:05 MST"}}, took {{.Run.Duration}}.
+{{.OK}} of {{len .Rows}} host(s) succeeded, {{.Failed}} failed{{if .Skipped}}, {{.Skipped}} skipped{{end}}.</p>
+<table id="results">
+<thead>
+<tr><th>Host</th><th>User</th><th>Port</th><th>Status</th><th>Exit code</th><th>Duration (s)</th><th>Output</th></tr>
+</thead>
+<tbody>
+{{range .Rows}}<tr class="{{if .Error}}failed{{else if eq .Status "skipped"}}skipped{{else}}ok{{end}}">
+<td>{{.Host}}</td><td>{{.User}}</td><td>{{.Port}}</td>
+<td class="status">{{if .Error}}{{.ErrorClass}}{{else if .Status}}{{.Status}}{{else}}ok{{end}}</td>
+<td>{{.ExitCode}}</td><td>{{.Seconds}}</td>
+<td><details><summary>{{if .Error}}{{.Error}}{{else}}show output{{end}}</summary>
+{{if .Stdout}}<strong>stdout</strong><pre>{{.Stdout}}</pre>{{end}}
+{{if .Stderr}}<strong>stderr</strong><pre>{{.Stderr}}</pre>{{end}}
+</details></td>
+</tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("#results th").forEach(function (th, col) {
+  var asc = true;
+  th.addEventListener("click", function () {
+    var body = document.querySelector("#results tbody");
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].innerText, y = b.cells[col].innerText;
+      var nx = parseFloat(x), ny = parseFloat(y);
+      var c = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+      return asc ? c : -c;
+    });
+    asc = !asc;
+    rows.forEach(function (r) { body.appendChild(r); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+type htmlRow struct {
+	Host, User, Stdout, Stderr, Error, ErrorClass, Status, Seconds string
+	Port, ExitCode                                                 int
+}
+
+// writeHTML writes a standalone page with a sortable per-host table whose
+// rows expand to show the host's output.
+func writeHTML(f *os.File, run reportRun, results []client.Result) error {
+	data := struct {
+		Run                 reportRun
+		Rows                []htmlRow
+		OK, Failed, Skipped int
+	}{Run: run}
+	for _, res := range sortedResults(results) {
+		row := htmlRow{
+			Host:       res.Host,
+			User:       res.User,
+			Port:       res.Port,
+			ExitCode:   res.ExitCode,
+			Stdout:     res.Stdout,
+			Stderr:     res.Stderr,
+			ErrorClass: res.ErrorClass(),
+			Status:     res.Status,
+			Seconds:    seconds(res.Duration),
+		}
+		if res.Error != nil {
+			row.Error = res.Error.Error()
+			data.Failed++
+		} else if res.Status == "skipped" {
+			data.Skipped++
+		} else {
+			data.OK++
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	return htmlReport.Execute(f, data)
+}
+
+// sortedResults returns results ordered by host so reports are stable
+// from run to run.
+func sortedResults(results []client.Result) []client.Result {
+	sorted := append([]client.Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Host < sorted[j].Host
+	})
+	return sorted
+}
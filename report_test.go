@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"godev/client"
+)
+
+// reportResults has one host of each kind a report tells apart.
+func reportResults() []client.Result {
+	return []client.Result{
+		{Host: "ok1", Stdout: "fine\n"},
+		{Host: "exit1", ExitCode: 3, Stderr: "no such unit\n", Error: errors.New("Process exited with status 3")},
+		{Host: "conn1", Error: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+		{Host: "auth1", Error: errors.New("ssh: handshake failed: ssh: unable to authenticate")},
+		{Host: "skip1", Status: "skipped", Stdout: "skipped: /opt/agent exists\n"},
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.xml")
+	run := reportRun{Name: "deploy", StartedAt: time.Now(), Duration: time.Second}
+	if err := writeReport(reportSpec{kind: "junit", path: path}, run, reportResults()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got junitSuites
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, data)
+	}
+	if len(got.Suites) != 1 {
+		t.Fatalf("got %d suites, want 1", len(got.Suites))
+	}
+	suite := got.Suites[0]
+	if suite.Tests != 5 || suite.Failures != 1 || suite.Errors != 2 || suite.Skipped != 1 {
+		t.Errorf("suite counts tests=%d failures=%d errors=%d skipped=%d, want 5, 1, 2, 1",
+			suite.Tests, suite.Failures, suite.Errors, suite.Skipped)
+	}
+
+	cases := map[string]junitCase{}
+	for _, tc := range suite.Cases {
+		cases[tc.Name] = tc
+	}
+	if tc := cases["ok1"]; tc.Failure != nil || tc.Error != nil || tc.Skipped != nil {
+		t.Errorf("ok1 = %+v, want a plain pass", tc)
+	}
+	if tc := cases["exit1"]; tc.Failure == nil || tc.Failure.Type != "exit" || tc.Failure.Body != "no such unit\n" {
+		t.Errorf("exit1 failure = %+v, want an exit failure carrying stderr", tc.Failure)
+	}
+	if tc := cases["conn1"]; tc.Error == nil || tc.Error.Type != "connect" {
+		t.Errorf("conn1 error = %+v, want a connect error", tc.Error)
+	}
+	if tc := cases["auth1"]; tc.Error == nil || tc.Error.Type != "auth" {
+		t.Errorf("auth1 error = %+v, want an auth error", tc.Error)
+	}
+	if tc := cases["skip1"]; tc.Skipped == nil || tc.Skipped.Message != "skipped: /opt/agent exists" {
+		t.Errorf("skip1 skipped = %+v, want the guard's reason", tc.Skipped)
+	}
+}
+
+func TestWriteHTMLEscapes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	results := append(reportResults(), client.Result{
+		Host:   "evil1",
+		Stdout: "<script>alert(1)</script>\n",
+		Stderr: "a & b\n",
+		Error:  errors.New(`bad "<img src=x onerror=alert(2)>"`),
+	})
+	run := reportRun{Name: "<b>deploy</b>", StartedAt: time.Now(), Duration: time.Second}
+	if err := writeReport(reportSpec{kind: "html", path: path}, run, results); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, raw := range []string{"<script>alert(1)", "<img src=x", "<b>deploy</b>"} {
+		if strings.Contains(page, raw) {
+			t.Errorf("report contains unescaped %q", raw)
+		}
+	}
+	for _, escaped := range []string{"&lt;script&gt;alert(1)&lt;/script&gt;", "a &amp; b", "&lt;b&gt;deploy&lt;/b&gt;"} {
+		if !strings.Contains(page, escaped) {
+			t.Errorf("report does not contain %q", escaped)
+		}
+	}
+	if n := strings.Count(page, `<tr class="failed">`); n != 4 {
+		t.Errorf("report has %d failed rows, want 4", n)
+	}
+	if n := strings.Count(page, `<tr class="skipped">`); n != 1 {
+		t.Errorf("report has %d skipped rows, want 1", n)
+	}
+}
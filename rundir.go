@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"godev/client"
+)
+
+// runManifest describes one run for audit purposes. It is written when the
+// run starts and rewritten with the end time and totals when it finishes.
+type runManifest struct {
+	RunID         string     `json:"run_id"`
+	LocalUser     string     `json:"local_user"`
+	SSHUser       string     `json:"ssh_user"`
+	CommandFile   string     `json:"command_file"`
+	CommandSHA256 string     `json:"command_sha256"`
+	Inventory     string     `json:"inventory,omitempty"`
+	InventorySHA  string     `json:"inventory_sha256,omitempty"`
+	Hosts         int        `json:"hosts"`
+	Succeeded     int        `json:"succeeded"`
+	Failed        int        `json:"failed"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// hostMeta is the <host>.json file written next to a host's output.
+type hostMeta struct {
+	Host       string    `json:"host"`
+	User       string    `json:"user"`
+	Port       int       `json:"port"`
+	ExitCode   int       `json:"exit_code"`
+	Signal     string    `json:"signal,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	StdoutFile string    `json:"stdout_file"`
+	StderrFile string    `json:"stderr_file"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	FailedStep int       `json:"failed_step,omitempty"`
+}
+
+// runDir is DIR/<run-id> as created by --output-dir. Host output is
+// streamed straight into it so it never has to be held in memory. Each
+// host's files are named after its LocalName.
+type runDir struct {
+	path     string
+	mu       sync.Mutex
+	manifest runManifest
+}
+
+func newRunDir(base string, manifest runManifest) (*runDir, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	manifest.RunID = manifest.StartedAt.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	d := &runDir{
+		path:     filepath.Join(base, manifest.RunID),
+		manifest: manifest,
+	}
+	if err := os.MkdirAll(d.path, 0o700); err != nil {
+		return nil, fmt.Errorf("create run directory: %w", err)
+	}
+	if err := d.writeJSON("manifest.json", d.manifest); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// hostFiles creates the stdout and stderr files for h.
+func (d *runDir) hostFiles(h client.HostInfo) (*os.File, *os.File, error) {
+	base := filepath.Join(d.path, h.LocalName)
+	stdout, err := os.OpenFile(base+".stdout", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := os.OpenFile(base+".stderr", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+// finishHost writes h's metadata and counts it in the manifest.
+func (d *runDir) finishHost(h client.HostInfo, res client.Result) error {
+	base := h.LocalName
+	meta := hostMeta{
+		Host:       res.Host,
+		User:       res.User,
+		Port:       res.Port,
+		ExitCode:   res.ExitCode,
+		Signal:     res.Signal,
+		Status:     res.Status,
+		ErrorClass: res.ErrorClass(),
+		StdoutFile: base + ".stdout",
+		StderrFile: base + ".stderr",
+		StartedAt:  res.StartedAt,
+		DurationMS: res.Duration.Milliseconds(),
+		FailedStep: res.FailedStep,
+	}
+	if res.Error != nil {
+		meta.Error = res.Error.Error()
+	}
+
+	d.mu.Lock()
+	if res.Error != nil {
+		d.manifest.Failed++
+	} else {
+		d.manifest.Succeeded++
+	}
+	d.mu.Unlock()
+
+	return d.writeJSON(base+".json", meta)
+}
+
+// finish records the end of the run in the manifest.
+func (d *runDir) finish() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.manifest.FinishedAt = &now
+	return d.writeJSON("manifest.json", d.manifest)
+}
+
+func (d *runDir) writeJSON(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(d.path, name), append(data, '\n'), 0o600)
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stringSHA256 returns the hex SHA-256 of s.
+func stringSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"godev/client"
+)
+
+func TestRunDirHostFiles(t *testing.T) {
+	hosts := []client.HostInfo{
+		{User: "root", Host: "web1", Port: 22},
+		{User: "root", Host: "db1", Port: 22},
+		{User: "app", Host: "db1", Port: 2222},
+	}
+	client.NameHosts(hosts)
+
+	d, err := newRunDir(t.TempDir(), runManifest{StartedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hosts {
+		stdout, stderr, err := d.hostFiles(h)
+		if err != nil {
+			t.Fatalf("hostFiles(%+v): %v", h, err)
+		}
+		stdout.Close()
+		stderr.Close()
+		if err := d.finishHost(h, client.Result{Host: h.Host}); err != nil {
+			t.Fatalf("finishHost(%+v): %v", h, err)
+		}
+	}
+
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{
+		"db1_22.json", "db1_22.stderr", "db1_22.stdout",
+		"db1_2222.json", "db1_2222.stderr", "db1_2222.stdout",
+		"manifest.json",
+		"web1.json", "web1.stderr", "web1.stdout",
+	}
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Fatalf("run directory holds %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("run directory holds %q, want %q", got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(d.path, "web1.stdout")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  a  b\tc\n", []string{"a", "b", "c"}},
+		{`'a b' "c d"`, []string{"a b", "c d"}},
+		{`'it''s' ""`, []string{"its", ""}},
+		{`a\ b \'q\'`, []string{"a b", "'q'"}},
+		{`"say \"hi\" \$HOME \n"`, []string{`say "hi" $HOME \n`}},
+		{`'no \escapes $here'`, []string{`no \escapes $here`}},
+		{`pre"mid"'end'`, []string{"premidend"}},
+	}
+	for _, tt := range tests {
+		got, err := splitShellWords(tt.in)
+		if err != nil {
+			t.Errorf("splitShellWords(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`'open`, `"open`, `trailing\`} {
+		if got, err := splitShellWords(in); err == nil {
+			t.Errorf("splitShellWords(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	for _, kv := range []string{"A=1", "_x=", "PATH_2=a=b", "K= spaced "} {
+		if got, err := parseEnv(kv); err != nil || got != kv {
+			t.Errorf("parseEnv(%q) = %q, %v; want it unchanged", kv, got, err)
+		}
+	}
+	for _, kv := range []string{"", "NOVALUE", "=1", "1A=x", "A-B=x", "A B=x"} {
+		if _, err := parseEnv(kv); err == nil {
+			t.Errorf("parseEnv(%q): no error", kv)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	content := "# settings\n\nexport A=1\nB='two words'\nC=\"x=y\"\nD=\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A=1", "B=two words", "C=x=y", "D="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readEnvFile = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("A=1\nhunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readEnvFile(path)
+	if err == nil || !strings.Contains(err.Error(), path+":2:") || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("readEnvFile with a bare line: error %v, want one naming %s:2 without the line", err, path)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+
+	"godev/client"
+)
+
+// shellHost is one host in a godev shell session. sh is nil while the
+// host is not connected, and err then says why.
+type shellHost struct {
+	info     client.HostInfo
+	sh       *client.Shell
+	err      error
+	focused  bool
+	excluded bool
+	// stopped is set when Ctrl-C closed the shell mid-command.
+	stopped bool
+	stdout  *lineWriter
+	stderr  *lineWriter
+}
+
+func (h *shellHost) name() string {
+	return net.JoinHostPort(h.info.Host, strconv.Itoa(h.info.Port))
+}
+
+// shellSession is the state of godev shell: the hosts, and which of them
+// are busy with the current command so Ctrl-C can stop it.
+type shellSession struct {
+	hosts       []*shellHost
+	opts        client.Options
+	stream      *streamer
+	interactive bool
+
+	mu   sync.Mutex
+	busy map[*shellHost]bool
+}
+
+const shellHelp = `Lines are run on every targeted host, each in its own persistent sh.
+  :hosts                 list hosts and their connection state
+  :focus HOST...         send only to these hosts (":focus" alone sends to all again)
+  :exclude HOST...       stop sending to these hosts
+  :include HOST...       send to excluded hosts again (":include" alone includes all)
+  :reconnect             reconnect hosts whose shell was lost
+  :help                  show this help
+  :quit                  close every shell and exit (or Ctrl-D)
+HOST is a host name, HOST:PORT or a pattern such as web*.
+Ctrl-C stops the running command by dropping the shells still busy with it.
+`
+
+// runShell opens a shell on every host and broadcasts each line read from
+// stdin to the hosts it targets, printing their output with the usual
+// host labels, until stdin ends or :quit.
+func runShell(hosts []client.HostInfo, opts client.Options) {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.Host
+	}
+	s := &shellSession{
+		opts:   opts,
+		stream: newStreamer(names),
+		busy:   map[*shellHost]bool{},
+	}
+	s.opts.SkipCapture = true
+	for _, h := range hosts {
+		sh := &shellHost{info: h}
+		sh.stdout, sh.stderr = s.stream.writers(h.Host)
+		s.hosts = append(s.hosts, sh)
+	}
+
+	s.connect(s.hosts)
+	connected := 0
+	for _, h := range s.hosts {
+		if h.sh != nil {
+			connected++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Connected to %d of %d host(s). Type :help for commands.\n", connected, len(s.hosts))
+
+	s.interactive = term.IsTerminal(int(os.Stdin.Fd()))
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			s.interrupt()
+		}
+	}()
+
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 64*1024), 1024*1024)
+	for {
+		if s.interactive {
+			s.prompt()
+		}
+		if !in.Scan() {
+			break
+		}
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			if !s.command(line) {
+				break
+			}
+			continue
+		}
+		s.broadcast(line)
+	}
+	if err := in.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading input:", err)
+	}
+	if s.interactive {
+		s.stream.printf("\n")
+	}
+
+	for _, h := range s.hosts {
+		if h.sh != nil {
+			h.sh.Close()
+		}
+	}
+}
+
+// connect opens shells on hosts, workerCount at a time.
+func (s *shellSession) connect(hosts []*shellHost) {
+	jobs := make(chan *shellHost, len(hosts))
+	for _, h := range hosts {
+		jobs <- h
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for h := range jobs {
+				opts := s.opts
+				opts.Stdout, opts.Stderr = h.stdout, h.stderr
+				h.sh, h.err = client.OpenShell(h.info, opts)
+				if h.err != nil {
+					s.stream.printf("%s %v\n", s.stream.label(h.info.Host), h.err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *shellSession) prompt() {
+	s.stream.printf("godev (%d/%d)> ", len(s.targets()), len(s.hosts))
+}
+
+// targets lists the connected hosts a line would be sent to.
+func (s *shellSession) targets() []*shellHost {
+	focus := false
+	for _, h := range s.hosts {
+		focus = focus || h.focused
+	}
+	var out []*shellHost
+	for _, h := range s.hosts {
+		if h.sh != nil && !h.excluded && (h.focused || !focus) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// broadcast runs line on every targeted host at once and waits for all of
+// them. Hosts that exit non-zero say so after their output; hosts whose
+// shell is lost are marked disconnected.
+func (s *shellSession) broadcast(line string) {
+	targets := s.targets()
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "No connected hosts to send to; see :hosts.")
+		return
+	}
+
+	s.mu.Lock()
+	for _, h := range targets {
+		s.busy[h] = true
+	}
+	s.mu.Unlock()
+
+	results := make([]client.Result, len(targets))
+	var wg sync.WaitGroup
+	for i, h := range targets {
+		wg.Add(1)
+		go func(i int, h *shellHost) {
+			defer wg.Done()
+			results[i] = h.sh.Run(line)
+			h.stdout.Flush()
+			h.stderr.Flush()
+			s.mu.Lock()
+			delete(s.busy, h)
+			s.mu.Unlock()
+		}(i, h)
+	}
+	wg.Wait()
+
+	for i, h := range targets {
+		res := results[i]
+		label := s.stream.label(h.info.Host)
+		switch {
+		case res.Error != nil:
+			h.sh.Close()
+			h.sh, h.err = nil, res.Error
+			if h.stopped {
+				h.err, h.stopped = fmt.Errorf("interrupted"), false
+			}
+			s.stream.printf("%s disconnected: %v\n", label, h.err)
+		case res.ExitCode != 0:
+			s.stream.printf("%s [exit %d]\n", label, res.ExitCode)
+		}
+	}
+}
+
+// interrupt stops the running command, if there is one, by closing the
+// shells still busy with it. Nothing else can reach a command that has no
+// terminal to send the signal through. With no command running it only
+// clears the prompt, unless input is not a terminal, when it exits.
+func (s *shellSession) interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.busy) == 0 {
+		if !s.interactive {
+			os.Exit(130)
+		}
+		s.stream.printf("\n")
+		s.prompt()
+		return
+	}
+	for h := range s.busy {
+		h.stopped = true
+		h.sh.Close()
+	}
+}
+
+// command handles a :command line and reports whether to keep going.
+func (s *shellSession) command(line string) bool {
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+	switch name {
+	case ":quit", ":exit", ":q":
+		return false
+	case ":help":
+		fmt.Fprint(os.Stderr, shellHelp)
+	case ":hosts":
+		s.listHosts()
+	case ":focus":
+		hosts, ok := s.match(args)
+		if !ok {
+			break
+		}
+		for _, h := range s.hosts {
+			h.focused = false
+		}
+		for _, h := range hosts {
+			h.focused = true
+		}
+	case ":exclude":
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: :exclude needs at least one HOST.")
+			break
+		}
+		hosts, ok := s.match(args)
+		if !ok {
+			break
+		}
+		for _, h := range hosts {
+			h.excluded = true
+		}
+	case ":include":
+		if len(args) == 0 {
+			for _, h := range s.hosts {
+				h.excluded = false
+			}
+			break
+		}
+		hosts, ok := s.match(args)
+		if !ok {
+			break
+		}
+		for _, h := range hosts {
+			h.excluded = false
+		}
+	case ":reconnect":
+		var lost []*shellHost
+		for _, h := range s.hosts {
+			if h.sh == nil {
+				lost = append(lost, h)
+			}
+		}
+		s.connect(lost)
+		s.listHosts()
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %s; type :help for the list.\n", name)
+	}
+	return true
+}
+
+// match finds the hosts each pattern names, by host name or HOST:PORT.
+// It complains and reports false when a pattern matches nothing.
+func (s *shellSession) match(patterns []string) ([]*shellHost, bool) {
+	var out []*shellHost
+	for _, p := range patterns {
+		found := false
+		for _, h := range s.hosts {
+			byHost, _ := path.Match(p, h.info.Host)
+			byName, _ := path.Match(p, h.name())
+			if byHost || byName {
+				out = append(out, h)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Error: no host matches %q; see :hosts.\n", p)
+			return nil, false
+		}
+	}
+	return out, true
+}
+
+// listHosts prints every host with its connection state and whether the
+// next line would be sent to it.
+func (s *shellSession) listHosts() {
+	targeted := map[*shellHost]bool{}
+	for _, h := range s.targets() {
+		targeted[h] = true
+	}
+	width := 0
+	for _, h := range s.hosts {
+		if len(h.name()) > width {
+			width = len(h.name())
+		}
+	}
+	for _, h := range s.hosts {
+		state := "connected"
+		if h.sh == nil {
+			state = fmt.Sprintf("disconnected: %v", h.err)
+		}
+		var notes []string
+		if h.focused {
+			notes = append(notes, "focused")
+		}
+		if h.excluded {
+			notes = append(notes, "excluded")
+		}
+		if !targeted[h] && h.sh != nil && !h.excluded {
+			notes = append(notes, "not targeted")
+		}
+		if len(notes) > 0 {
+			state += " (" + strings.Join(notes, ", ") + ")"
+		}
+		s.stream.printf("%-*s  %s\n", width, h.name(), state)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// hostColors are the ANSI foreground colors host labels cycle through.
+var hostColors = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// streamer prints remote output line by line as it arrives, each line
+// prefixed with a host label. Every line is written under one mutex, so
+// output from concurrent workers never interleaves mid-line.
+type streamer struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	color  bool
+	width  int
+}
+
+func newStreamer(hosts []string) *streamer {
+	s := &streamer{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		color:  os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd())),
+	}
+	for _, h := range hosts {
+		if len(h) > s.width {
+			s.width = len(h)
+		}
+	}
+	return s
+}
+
+func (s *streamer) label(host string) string {
+	label := fmt.Sprintf("%-*s |", s.width, host)
+	if !s.color {
+		return label
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", hostColors[h.Sum32()%uint32(len(hostColors))], label)
+}
+
+// writers returns line writers for host's stdout and stderr.
+func (s *streamer) writers(host string) (*lineWriter, *lineWriter) {
+	prefix := s.label(host)
+	return &lineWriter{s: s, w: s.stdout, prefix: prefix},
+		&lineWriter{s: s, w: s.stderr, prefix: prefix}
+}
+
+func (s *streamer) writeLine(w io.Writer, prefix string, line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "%s %s\n", prefix, line)
+}
+
+// lineWriter buffers partial lines and hands complete ones to its streamer.
+type lineWriter struct {
+	s      *streamer
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.s.writeLine(l.w, l.prefix, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out a trailing line that had no newline.
+func (l *lineWriter) Flush() {
+	if len(l.buf) > 0 {
+		l.s.writeLine(l.w, l.prefix, l.buf)
+		l.buf = nil
+	}
+}
+
+// printf writes a whole message to stdout without splitting any line that
+// is being streamed at the same time.
+func (s *streamer) printf(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.stdout, format, args...)
+}